@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"siddump/player"
+)
+
+// batchJob is one subtune of one tune in a batch run
+type batchJob struct {
+	SidName string
+	RelName string
+	Subtune int
+}
+
+// batchResult is the outcome of a batch job
+type batchResult struct {
+	Job batchJob
+	Err error
+}
+
+// Batch dumps every subtune of every .sid file below a directory, using
+// several workers. Outputs are written to outDir in the same directory
+// layout as the input.
+type Batch struct {
+	Dumper  *Dumper
+	Workers int
+	Out     io.Writer
+}
+
+func NewBatch(d *Dumper, workers int, out io.Writer) *Batch {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Batch{Dumper: d, Workers: workers, Out: out}
+}
+
+// Run processes all tunes below dir and prints a summary. It returns an
+// error if the tunes could not be listed or a job failed.
+func (b *Batch) Run(dir string, outDir string) error {
+	jobs, err := b.findJobs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return err
+	}
+	fmt.Fprintf(b.Out, "Dumping %d subtunes with %d workers\n", len(jobs), b.Workers)
+
+	queue := make(chan batchJob)
+	results := make(chan batchResult)
+
+	var wg sync.WaitGroup
+	for i := 0; i < b.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				results <- batchResult{Job: job, Err: b.runJob(job, outDir)}
+			}
+		}()
+	}
+
+	go func() {
+		for _, job := range jobs {
+			queue <- job
+		}
+		close(queue)
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed []batchResult
+	ok, timeouts := 0, 0
+	for r := range results {
+		switch {
+		case r.Err == nil:
+			ok++
+		case errors.Is(r.Err, ErrTimeout):
+			timeouts++
+			failed = append(failed, r)
+		default:
+			failed = append(failed, r)
+		}
+	}
+
+	sort.Slice(failed, func(i, j int) bool {
+		a, b := failed[i].Job, failed[j].Job
+		if a.RelName != b.RelName {
+			return a.RelName < b.RelName
+		}
+		return a.Subtune < b.Subtune
+	})
+
+	summary, err := os.Create(filepath.Join(outDir, "summary.txt"))
+	if err != nil {
+		return err
+	}
+	defer summary.Close()
+	w := io.MultiWriter(b.Out, summary)
+
+	fmt.Fprintf(w, "Succeeded: %d Failed: %d Timeouts: %d\n", ok, len(failed)-timeouts, timeouts)
+	for _, r := range failed {
+		fmt.Fprintf(w, "  %s subtune %d: %v\n", r.Job.RelName, r.Job.Subtune, r.Err)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d subtunes failed", len(failed), len(jobs))
+	}
+	return nil
+}
+
+// findJobs lists all subtunes of the .sid files below dir
+func (b *Batch) findJobs(dir string) ([]batchJob, error) {
+	var jobs []batchJob
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".sid") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		// Unreadable headers still get a job so they show in the summary
+		songs := 1
+		if tune, err := player.Open(path); err == nil && tune.Header.Songs > 0 {
+			songs = int(tune.Header.Songs)
+		}
+		for song := 0; song < songs; song++ {
+			jobs = append(jobs, batchJob{SidName: path, RelName: rel, Subtune: song})
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// runJob dumps one subtune. Text output goes to <outDir>/<tune>_<song>.txt,
+// output files are named after it.
+func (b *Batch) runJob(job batchJob, outDir string) (err error) {
+	// A broken tune must not take down the whole batch
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	rel := strings.TrimSuffix(job.RelName, filepath.Ext(job.RelName))
+	outBase := filepath.Join(outDir, fmt.Sprintf("%s_%d", rel, job.Subtune))
+	if err := os.MkdirAll(filepath.Dir(outBase), 0o755); err != nil {
+		return err
+	}
+
+	file, err := os.Create(outBase + ".txt")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	w := bufio.NewWriter(file)
+	defer func() {
+		// A full disk only shows when the buffer is written
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	// Every subtune gets its own heatmap, trace, memory map and text output
+	// files, named after the text output
+	opt := *b.Dumper.Options
+	if opt.Heatmap != "" {
+		opt.Heatmap = outBase + "_heatmap.png"
+	}
+	if opt.Trace != "" {
+		opt.Trace = outBase + "_" + filepath.Base(opt.Trace)
+	}
+	if opt.MemMap != "" {
+		opt.MemMap = outBase + "_" + filepath.Base(opt.MemMap)
+	}
+	opt.Outputs = nil
+	for _, spec := range b.Dumper.Options.Outputs {
+		if spec.Path != "" && spec.Path != "-" {
+			spec.Path = outBase + "_" + filepath.Base(spec.Path)
+		}
+		opt.Outputs = append(opt.Outputs, spec)
+	}
+	d := *b.Dumper
+	d.Options = &opt
+	return d.Dump(context.Background(), job.SidName, job.Subtune, w, w, outBase)
+}
@@ -0,0 +1,86 @@
+package c64
+
+import (
+	"github.com/beevik/go6502/cpu"
+)
+
+// Instructions that only write their operand, or do not access it at all
+var noOperandRead = map[string]bool{
+	"STA": true, "STX": true, "STY": true, "JMP": true, "JSR": true, "???": true,
+}
+
+// Reads returns the addresses the next instruction reads, apart from its
+// opcode and operand: the data it loads, the pointers of indirect
+// addressing and the stack. Its writes are seen by the store hooks.
+func Reads(c *cpu.CPU) []uint16 {
+	inst := c.InstSet.Lookup(c.Mem.LoadByte(c.Reg.PC))
+	sp := c.Reg.SP
+
+	switch inst.Name {
+	case "PLA", "PLP":
+		return []uint16{stack(sp + 1)}
+	case "RTS":
+		return []uint16{stack(sp + 1), stack(sp + 2)}
+	case "RTI":
+		return []uint16{stack(sp + 1), stack(sp + 2), stack(sp + 3)}
+	}
+
+	addr, ptr, ok := Operand(c)
+	if !ok {
+		return nil
+	}
+	var reads []uint16
+	switch inst.Mode {
+	case cpu.IND:
+		return []uint16{ptr, PointerHigh(ptr)}
+	case cpu.IDX, cpu.IDY:
+		reads = []uint16{ptr, PointerHigh(ptr)}
+	}
+	if noOperandRead[inst.Name] {
+		return reads
+	}
+	return append(reads, addr)
+}
+
+// Operand returns the address the next instruction accesses and, with
+// indirect addressing, the address of the pointer. ok is false for
+// instructions without a memory operand.
+func Operand(c *cpu.CPU) (addr uint16, ptr uint16, ok bool) {
+	pc := c.Reg.PC
+	inst := c.InstSet.Lookup(c.Mem.LoadByte(pc))
+	op := c.Mem.LoadByte(pc + 1)
+	abs := uint16(op) | uint16(c.Mem.LoadByte(pc+2))<<8
+
+	switch inst.Mode {
+	case cpu.ZPG:
+		return uint16(op), 0, true
+	case cpu.ZPX:
+		return uint16(op + c.Reg.X), 0, true
+	case cpu.ZPY:
+		return uint16(op + c.Reg.Y), 0, true
+	case cpu.ABS:
+		return abs, 0, true
+	case cpu.ABX:
+		return abs + uint16(c.Reg.X), 0, true
+	case cpu.ABY:
+		return abs + uint16(c.Reg.Y), 0, true
+	case cpu.IND:
+		return c.Mem.LoadAddress(abs), abs, true
+	case cpu.IDX:
+		zp := uint16(op + c.Reg.X)
+		return c.Mem.LoadAddress(zp), zp, true
+	case cpu.IDY:
+		return c.Mem.LoadAddress(uint16(op)) + uint16(c.Reg.Y), uint16(op), true
+	}
+	return 0, 0, false
+}
+
+// PointerHigh returns the address of the high byte of a pointer. Like on
+// the 6502 it does not cross a page.
+func PointerHigh(ptr uint16) uint16 {
+	return ptr&0xFF00 | (ptr+1)&0xFF
+}
+
+func stack(sp uint8) uint16 {
+	return 0x100 + uint16(sp)
+}
@@ -0,0 +1,229 @@
+package c64
+
+import (
+	"fmt"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// Maximum number of instructions the init or play routine may execute
+const MAX_INSTR uint16 = 0xFFFF
+
+// Memory is the 64K address space of the emulated C64. Stores to the SID
+// registers are counted, so rewrites of an unchanged value can be told
+// apart from registers that are not written at all.
+type Memory struct {
+	cpu.FlatMemory
+	SidWrites [25]uint8
+
+	// Hooks of debuggers and tracers
+	stepHooks  []StepHook
+	storeHooks []StoreHook
+}
+
+// StepHook is called before every instruction the init and play routines
+// execute. The routine stops with the error returned by the hook.
+type StepHook func(cpu *cpu.CPU, routine string, frame int) error
+
+// StoreHook is called before the CPU stores a byte in memory
+type StoreHook func(addr uint16, v byte)
+
+func NewMemory() *Memory {
+	mem := &Memory{}
+	return mem
+}
+
+// StoreByte stores a byte at the requested address.
+func (m *Memory) StoreByte(addr uint16, v byte) {
+	if addr >= 0xD400 && addr < 0xD419 && m.SidWrites[addr-0xD400] < 0xFF {
+		m.SidWrites[addr-0xD400]++
+	}
+	for _, hook := range m.storeHooks {
+		hook(addr, v)
+	}
+	m.FlatMemory.StoreByte(addr, v)
+}
+
+// OnStep adds a hook called before every instruction
+func OnStep(cpu *cpu.CPU, hook StepHook) {
+	if mem, ok := cpu.Mem.(*Memory); ok {
+		mem.stepHooks = append(mem.stepHooks, hook)
+	}
+}
+
+// OnStore adds a hook called before every store of the CPU
+func OnStore(cpu *cpu.CPU, hook StoreHook) {
+	if mem, ok := cpu.Mem.(*Memory); ok {
+		mem.storeHooks = append(mem.storeHooks, hook)
+	}
+}
+
+// callStepHooks runs the step hooks before the next instruction
+func callStepHooks(cpu *cpu.CPU, routine string, frame int) error {
+	mem, ok := cpu.Mem.(*Memory)
+	if !ok {
+		return nil
+	}
+	for _, hook := range mem.stepHooks {
+		if err := hook(cpu, routine, frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// poke stores a byte without calling the hooks, for changes made by the
+// emulated hardware rather than by the CPU
+func poke(cpu *cpu.CPU, addr uint16, v byte) {
+	if mem, ok := cpu.Mem.(*Memory); ok {
+		mem.FlatMemory.StoreByte(addr, v)
+		return
+	}
+	cpu.Mem.StoreByte(addr, v)
+}
+
+func NewCpu() *cpu.CPU {
+	mem := NewMemory()
+	CPU := cpu.NewCPU(cpu.NMOS, mem)
+	return CPU
+}
+
+// ClearSidWrites resets the SID register write counters
+func ClearSidWrites(cpu *cpu.CPU) {
+	if mem, ok := cpu.Mem.(*Memory); ok {
+		mem.SidWrites = [25]uint8{}
+	}
+}
+
+func Init(cpu *cpu.CPU, newpc uint16, newa uint8, newx uint8, newy uint8) *cpu.CPU {
+	cpu.SetPC(newpc)
+	cpu.Reg.X = newx
+	cpu.Reg.Y = newy
+	cpu.Reg.A = newa
+	return cpu
+}
+
+func Run(cpu *cpu.CPU) uint8 {
+	cpu.Step()
+
+	// DumpCpuState(cpu)
+
+	// Peek at the next opcode at the current PC
+	opcode := cpu.Mem.LoadByte(cpu.Reg.PC)
+
+	// Look up the instruction data for the opcode
+	inst := cpu.InstSet.Lookup(opcode)
+
+	switch {
+	case (inst.Opcode == 0x00):
+		return 0
+	case (inst.Opcode == 0x40) && (cpu.Reg.SP == 0xFF):
+		return 0
+	case (inst.Opcode == 0x60) && (cpu.Reg.SP == 0xFF):
+		return 0
+	}
+
+	return 1
+}
+
+// Opcodes that halt the 6502 until it is reset
+var jamOpcodes = map[uint8]bool{
+	0x02: true, 0x12: true, 0x22: true, 0x32: true, 0x42: true, 0x52: true,
+	0x62: true, 0x72: true, 0x92: true, 0xB2: true, 0xD2: true, 0xF2: true,
+}
+
+// Jammed reports whether the next instruction would halt the CPU
+func Jammed(cpu *cpu.CPU) bool {
+	return jamOpcodes[cpu.Mem.LoadByte(cpu.Reg.PC)]
+}
+
+func IncrementValueAtAddress(cpu *cpu.CPU, adr uint16) {
+	cpu.Mem.StoreByte(adr, cpu.Mem.LoadByte(adr)+1)
+}
+
+func PrintState(cpu *cpu.CPU) {
+	fmt.Printf("PC: %04x OP: %02x A:%02x X:%02x Y:%02x\n", cpu.LastPC, cpu.Mem.LoadByte(cpu.LastPC), cpu.Reg.A, cpu.Reg.X, cpu.Reg.Y)
+}
+
+// CallInit runs the init routine of a tune with the subtune number in the
+// accumulator. The raster counter is advanced on every instruction, so
+// routines waiting for a raster line get there.
+func CallInit(cpu *cpu.CPU, addr uint16, subtune uint8) error {
+	poke(cpu, 0x01, 0x37)
+	Init(cpu, addr, subtune, 0, 0)
+	instr := 0
+
+	for !Jammed(cpu) {
+		if err := callStepHooks(cpu, "init", 0); err != nil {
+			return err
+		}
+		if Run(cpu) != 1 {
+			break
+		}
+		poke(cpu, 0xD012, cpu.Mem.LoadByte(0xD012)+1)
+		if (cpu.Mem.LoadByte(0xD012) == 0) || (((cpu.Mem.LoadByte(0xD011) & 0x80) != 0) && (cpu.Mem.LoadByte(0xd012) >= 0x38)) {
+			tmp := cpu.Mem.LoadByte(0xD011)
+			tmp ^= 0x80
+			poke(cpu, 0xD011, tmp)
+			poke(cpu, 0xD012, 0x0)
+		}
+		instr += 1
+
+		if instr > int(MAX_INSTR) {
+			return newCPUError(cpu, "init", 0, ErrInitTimeout)
+		}
+	}
+	if Jammed(cpu) {
+		return newCPUError(cpu, "init", 0, ErrCPUJam)
+	}
+	return nil
+}
+
+// CallPlay runs the play routine for one frame
+func CallPlay(cpu *cpu.CPU, addr uint16, frame int) error {
+	Init(cpu, addr, 0, 0, 0)
+	instr := 0
+
+	for !Jammed(cpu) {
+		if err := callStepHooks(cpu, "play", frame); err != nil {
+			return err
+		}
+		if Run(cpu) != 1 {
+			break
+		}
+		instr += 1
+
+		if instr > int(MAX_INSTR) {
+			return newCPUError(cpu, "play", frame, ErrPlayTimeout)
+		}
+
+		// Test for jump into Kernal interrupt handler exit
+		if ((cpu.Mem.LoadByte(0x01) & 0x07) != 0x5) && (cpu.Reg.PC == 0xEA31 || cpu.Reg.PC == 0xEA81) {
+			break
+		}
+	}
+	if Jammed(cpu) {
+		return newCPUError(cpu, "play", frame, ErrCPUJam)
+	}
+	return nil
+}
+
+// IRQVector returns the address of the interrupt handler the init routine
+// installed, for tunes without a play address
+func IRQVector(cpu *cpu.CPU) uint16 {
+	if cpu.Mem.LoadByte(0x01)&0x07 == 0x5 {
+		return uint16(cpu.Mem.LoadByte(0xFFFE)) | (uint16(cpu.Mem.LoadByte(0xFFFF)) << 8)
+	}
+	return uint16(cpu.Mem.LoadByte(0x314)) | (uint16(cpu.Mem.LoadByte(0x315)) << 8)
+}
+
+// newCPUError describes the instruction the CPU stopped at
+func newCPUError(cpu *cpu.CPU, routine string, frame int, err error) error {
+	return &CPUError{
+		Routine: routine,
+		Frame:   frame,
+		PC:      cpu.Reg.PC,
+		Opcode:  cpu.Mem.LoadByte(cpu.Reg.PC),
+		Err:     err,
+	}
+}
@@ -0,0 +1,36 @@
+package c64
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors of the emulated CPU. They are wrapped in a CPUError, test for
+// them with errors.Is.
+var (
+	ErrCPUJam      = errors.New("CPU jam")
+	ErrInitTimeout = errors.New("routine did not return")
+	ErrPlayTimeout = errors.New("routine ran too long")
+)
+
+// CPUError reports where the emulated CPU failed while running the init
+// or play routine
+type CPUError struct {
+	Routine string
+	Frame   int
+	PC      uint16
+	Opcode  uint8
+	Err     error
+}
+
+func (e *CPUError) Error() string {
+	where := e.Routine + " routine"
+	if e.Routine == "play" {
+		where += fmt.Sprintf(", frame %d", e.Frame)
+	}
+	return fmt.Sprintf("%s: %v at $%04X (opcode $%02X)", where, e.Err, e.PC, e.Opcode)
+}
+
+func (e *CPUError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,136 @@
+package compare
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrBadDump = errors.New("register dump is not a whole number of frames")
+
+// Number of bytes per frame in a register dump: the 25 SID registers and
+// the frame time
+const FRAME_SIZE = 27
+
+// Registers compared, the frame time is not
+const REGISTERS = 25
+
+// Frames are the SID registers of every frame of a tune
+type Frames [][FRAME_SIZE]byte
+
+// ReadDump reads a binary register dump, as written by output mode 4
+func ReadDump(r io.Reader) (Frames, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%FRAME_SIZE != 0 {
+		return nil, ErrBadDump
+	}
+	frames := make(Frames, len(data)/FRAME_SIZE)
+	for i := range frames {
+		copy(frames[i][:], data[i*FRAME_SIZE:])
+	}
+	return frames, nil
+}
+
+// Result is the comparison of two tunes. Frame n of A is compared with
+// frame n+Offset of B.
+type Result struct {
+	Offset int
+	// Number of frames compared
+	Frames int
+	// First frame of A that differs, -1 when all frames are the same
+	First int
+	// Number of differing frames, in total and per register
+	Differing int
+	Registers [REGISTERS]int
+}
+
+// Compare compares the registers of two tunes frame by frame. Offsets of
+// B up to tolerance frames in both directions are tried, the one with the
+// smallest share of differing frames is used.
+func Compare(a, b Frames, tolerance int) *Result {
+	best := compareAt(a, b, 0)
+	for shift := 1; shift <= tolerance; shift++ {
+		for _, offset := range []int{-shift, shift} {
+			if res := compareAt(a, b, offset); res.Differing*best.Frames < best.Differing*res.Frames {
+				best = res
+			}
+		}
+	}
+	return best
+}
+
+func compareAt(a, b Frames, offset int) *Result {
+	res := &Result{Offset: offset, First: -1}
+	for frame := max(0, -offset); frame < len(a) && frame+offset < len(b); frame++ {
+		res.Frames++
+		differs := false
+		for reg := 0; reg < REGISTERS; reg++ {
+			if a[frame][reg] != b[frame+offset][reg] {
+				res.Registers[reg]++
+				differs = true
+			}
+		}
+		if differs {
+			res.Differing++
+			if res.First < 0 {
+				res.First = frame
+			}
+		}
+	}
+	return res
+}
+
+// PrintResult prints the offset, the first differing frame and the number
+// of differences per register. Registers are numbered in decimal like the
+// columns of WriteSideBySide.
+func (res *Result) PrintResult(w io.Writer) {
+	switch {
+	case res.Offset > 0:
+		fmt.Fprintf(w, "B is %d frame(s) behind A\n", res.Offset)
+	case res.Offset < 0:
+		fmt.Fprintf(w, "B is %d frame(s) ahead of A\n", -res.Offset)
+	}
+	if res.First < 0 {
+		fmt.Fprintf(w, "Same SID registers for %d frames\n", res.Frames)
+		return
+	}
+	fmt.Fprintf(w, "First difference at frame %d of A, frame %d of B\n", res.First, res.First+res.Offset)
+	fmt.Fprintf(w, "Differing frames: %d of %d\n", res.Differing, res.Frames)
+	for reg, count := range res.Registers {
+		if count > 0 {
+			fmt.Fprintf(w, "Register %02d: %d\n", reg, count)
+		}
+	}
+}
+
+// WriteSideBySide writes frames of A next to the matching ones of B in the
+// layout of the register output. Differing registers are marked with *.
+func (res *Result) WriteSideBySide(w io.Writer, a, b Frames, from int, count int) {
+	header := "| Frame | 00 01 02 03 04 05 06 | 07 08 09 10 11 12 13 | 14 15 16 17 18 19 20 | 21 22 23 24 |"
+	fmt.Fprintf(w, "%s%s\n", header, header[1:])
+	for frame := max(from, 0, -res.Offset); frame < from+count && frame < len(a) && frame+res.Offset < len(b); frame++ {
+		fa, fb := &a[frame], &b[frame+res.Offset]
+		line := "|"
+		for _, side := range []struct {
+			frame int
+			regs  *[FRAME_SIZE]byte
+		}{{frame, fa}, {frame + res.Offset, fb}} {
+			line += fmt.Sprintf(" %5d | ", side.frame)
+			for reg := 0; reg < REGISTERS; reg++ {
+				mark := " "
+				if fa[reg] != fb[reg] {
+					mark = "*"
+				}
+				line += fmt.Sprintf("%02X%s", side.regs[reg], mark)
+				if reg == 6 || reg == 13 || reg == 20 {
+					line += "| "
+				}
+			}
+			line += "|"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,127 @@
+package compare
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// frames builds a register dump with register 0 set to the given values
+// and register 24 to the volume $0F
+func frames(values ...byte) Frames {
+	f := make(Frames, len(values))
+	for i, v := range values {
+		f[i][0] = v
+		f[i][24] = 0x0F
+		f[i][25] = byte(i)
+	}
+	return f
+}
+
+func TestReadDump(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		frames int
+		err    error
+	}{
+		{"empty", nil, 0, nil},
+		{"two frames", make([]byte, 2*FRAME_SIZE), 2, nil},
+		{"partial frame", make([]byte, FRAME_SIZE+1), 0, ErrBadDump},
+	}
+	for _, test := range tests {
+		got, err := ReadDump(bytes.NewReader(test.data))
+		if !errors.Is(err, test.err) || len(got) != test.frames {
+			t.Errorf("%s: got %d frames, error %v, want %d, %v", test.name, len(got), err, test.frames, test.err)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a := frames(1, 2, 3, 4, 5, 6, 7, 8)
+	tests := []struct {
+		name      string
+		b         Frames
+		tolerance int
+		offset    int
+		first     int
+		differing int
+	}{
+		{"same", frames(1, 2, 3, 4, 5, 6, 7, 8), 2, 0, -1, 0},
+		{"B behind", frames(0, 1, 2, 3, 4, 5, 6, 7), 2, 1, -1, 0},
+		{"B ahead", frames(2, 3, 4, 5, 6, 7, 8, 9), 2, -1, -1, 0},
+		{"B behind, no tolerance", frames(0, 1, 2, 3, 4, 5, 6, 7), 0, 0, 0, 8},
+		{"two frames differ", frames(1, 2, 9, 4, 5, 9, 7, 8), 2, 0, 2, 2},
+		{"B shorter", frames(1, 2, 3), 0, 0, -1, 0},
+	}
+	for _, test := range tests {
+		res := Compare(a, test.b, test.tolerance)
+		if res.Offset != test.offset || res.First != test.first || res.Differing != test.differing {
+			t.Errorf("%s: offset %d, first %d, differing %d, want %d, %d, %d", test.name,
+				res.Offset, res.First, res.Differing, test.offset, test.first, test.differing)
+		}
+		if res.Registers[0] != test.differing || res.Registers[24] != 0 {
+			t.Errorf("%s: registers %v", test.name, res.Registers)
+		}
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	tests := []struct {
+		name string
+		res  Result
+		want string
+	}{
+		{"same", Result{Frames: 8, First: -1}, "Same SID registers for 8 frames\n"},
+		{"behind", Result{Offset: 1, Frames: 7, First: -1}, "B is 1 frame(s) behind A\nSame SID registers for 7 frames\n"},
+		{"ahead", Result{Offset: -2, Frames: 6, First: 3, Differing: 1, Registers: [REGISTERS]int{4: 1}},
+			"B is 2 frame(s) ahead of A\nFirst difference at frame 3 of A, frame 1 of B\nDiffering frames: 1 of 6\nRegister 04: 1\n"},
+		{"register past 9", Result{Frames: 6, First: 0, Differing: 1, Registers: [REGISTERS]int{15: 1}},
+			"First difference at frame 0 of A, frame 0 of B\nDiffering frames: 1 of 6\nRegister 15: 1\n"},
+	}
+	for _, test := range tests {
+		var sb strings.Builder
+		test.res.PrintResult(&sb)
+		if sb.String() != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, sb.String(), test.want)
+		}
+	}
+}
+
+func TestWriteSideBySide(t *testing.T) {
+	a := frames(1, 2, 3)
+	b := frames(0, 1, 9)
+	var sb strings.Builder
+	res := Compare(a, b, 1)
+	res.WriteSideBySide(&sb, a, b, 0, 10)
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	// The header and the frames of A that have a frame in B
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want 3:\n%s", len(lines), sb.String())
+	}
+	same := "|     0 | 01 00 00 00 00 00 00 | 00 00 00 00 00 00 00 | 00 00 00 00 00 00 00 | 00 00 00 0F |     1 | 01 00 00 00 00 00 00 | 00 00 00 00 00 00 00 | 00 00 00 00 00 00 00 | 00 00 00 0F |"
+	if lines[1] != same {
+		t.Errorf("same frame\n got %q\nwant %q", lines[1], same)
+	}
+	if !strings.HasPrefix(lines[2], "|     1 | 02*") || !strings.Contains(lines[2], "|     2 | 09*") {
+		t.Errorf("differing frame not marked: %q", lines[2])
+	}
+}
+
+func TestWriteSideBySideStart(t *testing.T) {
+	// B is behind and the first difference is at the first frame, so the
+	// context before it starts before frame 0
+	a := frames(9, 2, 3, 4, 5)
+	b := frames(0, 1, 2, 3, 4)
+	res := Compare(a, b, 1)
+	if res.Offset != 1 || res.First != 0 {
+		t.Fatalf("offset %d, first %d, want 1, 0", res.Offset, res.First)
+	}
+	var sb strings.Builder
+	res.WriteSideBySide(&sb, a, b, res.First-2, 3)
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[1], "|     0 | 09*") {
+		t.Errorf("got\n%s", sb.String())
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"siddump/debugger"
+)
+
+// Debug plays a subtune with the debugger attached, reading its commands
+// from in. The emulation starts stopped at the init routine.
+func (d *Dumper) Debug(sidName string, subtune int, in io.Reader, out io.Writer) error {
+	tune, err := d.open(sidName)
+	if err != nil {
+		return err
+	}
+	tune.Log = out
+	tune.Header.PrintPSIDVitals(out)
+
+	dbg := debugger.NewDebugger(in, out)
+	tune.Setup = append(tune.Setup, dbg.Attach)
+
+	playback, err := tune.Play(subtune, d.Options.Seconds*50)
+	if errors.Is(err, debugger.ErrQuit) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for range playback.Frames(context.Background()) {
+	}
+	if err := playback.Err(); errors.Is(err, debugger.ErrQuit) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Played %d frames\n", playback.Frame+1)
+	return nil
+}
@@ -0,0 +1,361 @@
+package debugger
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"siddump/c64"
+
+	"github.com/beevik/go6502/cpu"
+	"github.com/beevik/go6502/disasm"
+)
+
+// ErrQuit stops the emulation when the user quits the debugger
+var ErrQuit = errors.New("debugger quit")
+
+// Kinds of breakpoints
+const (
+	BREAK_PC = iota
+	BREAK_FRAME
+	BREAK_SID
+	WATCH
+)
+
+// Number of executed instructions shown before the PC by disasm
+const HISTORY = 4
+
+type Breakpoint struct {
+	Kind int
+	// Address, frame number or SID register
+	Value int
+}
+
+func (b *Breakpoint) String() string {
+	switch b.Kind {
+	case BREAK_FRAME:
+		return fmt.Sprintf("frame %d", b.Value)
+	case BREAK_SID:
+		return fmt.Sprintf("SID register $%02X", b.Value)
+	case WATCH:
+		return fmt.Sprintf("watch $%04X", b.Value)
+	}
+	return fmt.Sprintf("PC $%04X", b.Value)
+}
+
+// Debugger stops the init and play routines at breakpoints and reads
+// commands from a line-based prompt
+type Debugger struct {
+	In  *bufio.Scanner
+	Out io.Writer
+
+	// Breakpoints by number, deleted ones are nil
+	Breakpoints []*Breakpoint
+
+	cpu     *cpu.CPU
+	routine string
+	frame   int
+
+	// Instructions left to single-step, 0 when running
+	stepping int
+	// Reason to stop before the next instruction, set by stores
+	pending string
+	// Addresses of the last executed instructions
+	history []uint16
+	last    string
+}
+
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{In: bufio.NewScanner(in), Out: out, pending: "Start", frame: -1}
+}
+
+// Attach installs the debugger on a machine before the tune is loaded
+func (d *Debugger) Attach(c *cpu.CPU) {
+	d.cpu = c
+	c64.OnStep(c, d.step)
+	c64.OnStore(c, d.store)
+}
+
+func (d *Debugger) step(c *cpu.CPU, routine string, frame int) error {
+	reason := d.pending
+	d.pending = ""
+
+	// First instruction of a routine
+	if routine != d.routine || frame != d.frame {
+		d.routine, d.frame = routine, frame
+		d.history = d.history[:0]
+		for n, b := range d.Breakpoints {
+			if b != nil && b.Kind == BREAK_FRAME && routine == "play" && b.Value == frame {
+				reason = fmt.Sprintf("Breakpoint %d: %s", n+1, b)
+			}
+		}
+	}
+	for n, b := range d.Breakpoints {
+		if b != nil && b.Kind == BREAK_PC && uint16(b.Value) == c.Reg.PC {
+			reason = fmt.Sprintf("Breakpoint %d: %s", n+1, b)
+		}
+	}
+	if d.stepping > 0 {
+		d.stepping--
+		if d.stepping == 0 && reason == "" {
+			reason = "Step"
+		}
+	}
+
+	if reason != "" {
+		d.stepping = 0
+		fmt.Fprintf(d.Out, "%s (%s routine, frame %d)\n", reason, d.routine, d.frame)
+		d.printInstruction(c.Reg.PC, ">")
+		if err := d.prompt(); err != nil {
+			return err
+		}
+	}
+
+	if len(d.history) == HISTORY {
+		d.history = d.history[1:]
+	}
+	d.history = append(d.history, c.Reg.PC)
+	return nil
+}
+
+func (d *Debugger) store(addr uint16, v byte) {
+	old := d.cpu.Mem.LoadByte(addr)
+	for n, b := range d.Breakpoints {
+		switch {
+		case b == nil:
+		case b.Kind == BREAK_SID && addr == 0xD400+uint16(b.Value),
+			b.Kind == WATCH && addr == uint16(b.Value):
+			d.pending = fmt.Sprintf("Breakpoint %d: %s written by $%04X: $%02X -> $%02X", n+1, b, d.cpu.LastPC, old, v)
+		}
+	}
+}
+
+// prompt reads commands until one resumes the emulation
+func (d *Debugger) prompt() error {
+	for {
+		fmt.Fprint(d.Out, "(siddump) ")
+		if !d.In.Scan() {
+			fmt.Fprintln(d.Out)
+			return ErrQuit
+		}
+		line := strings.TrimSpace(d.In.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		resume, err := d.command(fields[0], fields[1:])
+		if errors.Is(err, ErrQuit) {
+			return err
+		}
+		if err != nil {
+			fmt.Fprintln(d.Out, "Error:", err)
+		}
+		if resume {
+			return nil
+		}
+	}
+}
+
+// command runs one command and reports whether the emulation resumes
+func (d *Debugger) command(name string, args []string) (bool, error) {
+	c := d.cpu
+	switch name {
+	case "b", "break":
+		return false, d.addBreakpoint(args)
+	case "w", "watch":
+		if len(args) != 1 {
+			return false, errors.New("usage: watch <address>")
+		}
+		addr, err := parseAddress(args[0])
+		if err != nil {
+			return false, err
+		}
+		d.add(&Breakpoint{Kind: WATCH, Value: int(addr)})
+	case "d", "delete":
+		if len(args) != 1 {
+			return false, errors.New("usage: delete <number>")
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > len(d.Breakpoints) || d.Breakpoints[n-1] == nil {
+			return false, fmt.Errorf("no breakpoint %s", args[0])
+		}
+		d.Breakpoints[n-1] = nil
+	case "bl", "breakpoints":
+		for n, b := range d.Breakpoints {
+			if b != nil {
+				fmt.Fprintf(d.Out, "%d: %s\n", n+1, b)
+			}
+		}
+	case "s", "step":
+		d.stepping = 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return false, fmt.Errorf("bad step count %s", args[0])
+			}
+			d.stepping = n
+		}
+		return true, nil
+	case "c", "continue":
+		return true, nil
+	case "r", "regs":
+		fmt.Fprintf(d.Out, "%s Cycles=%d\n", disasm.GetRegisterString(&c.Reg), c.Cycles)
+	case "m", "mem":
+		return false, d.dumpMemory(args)
+	case "u", "disasm":
+		return false, d.disassemble(args)
+	case "sid":
+		d.dumpSid()
+	case "q", "quit":
+		return false, ErrQuit
+	case "h", "help":
+		fmt.Fprint(d.Out, HELP)
+	default:
+		return false, fmt.Errorf("unknown command %s, try help", name)
+	}
+	return false, nil
+}
+
+const HELP = `break <addr>        stop when the PC reaches an address
+break frame <n>     stop at the start of the play routine of a frame
+break sid <reg>     stop after a write to a SID register ($00-$18)
+watch <addr>        stop after a write to a memory address
+breakpoints         list breakpoints
+delete <n>          delete a breakpoint
+step [n]            execute one or n instructions
+continue            run until the next breakpoint
+regs                show the CPU registers
+mem <addr> [len]    show memory
+disasm [addr] [n]   disassemble, around the PC without an address
+sid                 show the SID registers
+quit                stop the emulation
+An empty line repeats the last command. Addresses are hexadecimal.
+`
+
+func (d *Debugger) add(b *Breakpoint) {
+	d.Breakpoints = append(d.Breakpoints, b)
+	fmt.Fprintf(d.Out, "Breakpoint %d: %s\n", len(d.Breakpoints), b)
+}
+
+func (d *Debugger) addBreakpoint(args []string) error {
+	switch {
+	case len(args) == 1:
+		addr, err := parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		d.add(&Breakpoint{Kind: BREAK_PC, Value: int(addr)})
+	case len(args) == 2 && args[0] == "frame":
+		frame, err := strconv.Atoi(args[1])
+		if err != nil || frame < 0 {
+			return fmt.Errorf("bad frame %s", args[1])
+		}
+		d.add(&Breakpoint{Kind: BREAK_FRAME, Value: frame})
+	case len(args) == 2 && args[0] == "sid":
+		reg, err := parseAddress(args[1])
+		if reg >= 0xD400 {
+			reg -= 0xD400
+		}
+		if err != nil || reg > 0x18 {
+			return fmt.Errorf("bad SID register %s", args[1])
+		}
+		d.add(&Breakpoint{Kind: BREAK_SID, Value: int(reg)})
+	default:
+		return errors.New("usage: break <address> | break frame <n> | break sid <register>")
+	}
+	return nil
+}
+
+func (d *Debugger) dumpMemory(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: mem <address> [length]")
+	}
+	addr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+	length := 64
+	if len(args) == 2 {
+		if length, err = strconv.Atoi(args[1]); err != nil || length < 1 {
+			return fmt.Errorf("bad length %s", args[1])
+		}
+	}
+	for i := 0; i < length; i += 16 {
+		line := fmt.Sprintf("%04X:", addr+uint16(i))
+		for j := i; j < i+16 && j < length; j++ {
+			line += fmt.Sprintf(" %02X", d.cpu.Mem.LoadByte(addr+uint16(j)))
+		}
+		fmt.Fprintln(d.Out, line)
+	}
+	return nil
+}
+
+func (d *Debugger) disassemble(args []string) error {
+	count := 8
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("bad count %s", args[1])
+		}
+		count = n
+	}
+	if len(args) > 0 {
+		addr, err := parseAddress(args[0])
+		if err != nil {
+			return err
+		}
+		for i := 0; i < count; i++ {
+			addr = d.printInstruction(addr, " ")
+		}
+		return nil
+	}
+
+	// Instructions executed before the PC, then the ones following it
+	for _, pc := range d.history {
+		d.printInstruction(pc, " ")
+	}
+	addr := d.printInstruction(d.cpu.Reg.PC, ">")
+	for i := 1; i < count; i++ {
+		addr = d.printInstruction(addr, " ")
+	}
+	return nil
+}
+
+func (d *Debugger) printInstruction(addr uint16, mark string) uint16 {
+	line, next := disasm.Disassemble(d.cpu, addr, disasm.ShowBasic, "", nil)
+	fmt.Fprintf(d.Out, "%s %s\n", mark, line)
+	return next
+}
+
+func (d *Debugger) dumpSid() {
+	for voice := 0; voice < 3; voice++ {
+		line := fmt.Sprintf("Voice %d:", voice+1)
+		for reg := 0; reg < 7; reg++ {
+			line += fmt.Sprintf(" %02X", d.cpu.Mem.LoadByte(0xD400+uint16(voice*7+reg)))
+		}
+		fmt.Fprintln(d.Out, line)
+	}
+	line := "Filter: "
+	for reg := 21; reg < 25; reg++ {
+		line += fmt.Sprintf(" %02X", d.cpu.Mem.LoadByte(0xD400+uint16(reg)))
+	}
+	fmt.Fprintln(d.Out, line)
+}
+
+// parseAddress reads a hexadecimal address, with an optional $ or 0x prefix
+func parseAddress(s string) (uint16, error) {
+	hex := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "$"), "0x")
+	addr, err := strconv.ParseUint(hex, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %s", s)
+	}
+	return uint16(addr), nil
+}
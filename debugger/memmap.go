@@ -0,0 +1,173 @@
+package debugger
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+
+	"siddump/c64"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// Kinds of memory accesses
+const (
+	EXECUTED = 1 << iota
+	READ
+	WRITTEN
+)
+
+// MemoryMap records how every address was accessed by the init routine
+// and by the play routine
+type MemoryMap struct {
+	Init [0x10000]uint8
+	Play [0x10000]uint8
+
+	current *[0x10000]uint8
+}
+
+func NewMemoryMap() *MemoryMap {
+	m := &MemoryMap{}
+	m.current = &m.Init
+	return m
+}
+
+// Attach installs the memory map on a machine before the tune is loaded
+func (m *MemoryMap) Attach(c *cpu.CPU) {
+	c64.OnStep(c, m.step)
+	c64.OnStore(c, m.store)
+}
+
+func (m *MemoryMap) step(c *cpu.CPU, routine string, frame int) error {
+	m.current = &m.Init
+	if routine == "play" {
+		m.current = &m.Play
+	}
+
+	inst := c.InstSet.Lookup(c.Mem.LoadByte(c.Reg.PC))
+	for i := uint16(0); i < uint16(inst.Length); i++ {
+		m.current[c.Reg.PC+i] |= EXECUTED
+	}
+	for _, addr := range c64.Reads(c) {
+		m.current[addr] |= READ
+	}
+	return nil
+}
+
+func (m *MemoryMap) store(addr uint16, v byte) {
+	m.current[addr] |= WRITTEN
+}
+
+// WriteRanges writes the ranges of addresses accessed the same way, e.g.
+// "$1000-$10FF x--" for code that is only executed
+func (m *MemoryMap) WriteRanges(w io.Writer) error {
+	for _, routine := range []struct {
+		name string
+		mem  *[0x10000]uint8
+	}{{"Init", &m.Init}, {"Play", &m.Play}} {
+		if _, err := fmt.Fprintf(w, "%s routine:\n", routine.name); err != nil {
+			return err
+		}
+		for start := 0; start < 0x10000; {
+			end := start
+			for end+1 < 0x10000 && routine.mem[end+1] == routine.mem[start] {
+				end++
+			}
+			if flags := routine.mem[start]; flags != 0 {
+				if _, err := fmt.Fprintf(w, "$%04X-$%04X %s\n", start, end, accessString(flags)); err != nil {
+					return err
+				}
+			}
+			start = end + 1
+		}
+	}
+	return nil
+}
+
+func accessString(flags uint8) string {
+	s := []byte("---")
+	if flags&EXECUTED != 0 {
+		s[0] = 'x'
+	}
+	if flags&READ != 0 {
+		s[1] = 'r'
+	}
+	if flags&WRITTEN != 0 {
+		s[2] = 'w'
+	}
+	return string(s)
+}
+
+// WriteImage writes the map as a 256x256 PNG, one pixel per address with
+// a page per row. Executed addresses are red, read ones green and written
+// ones blue, at half brightness when only the init routine accessed them.
+func (m *MemoryMap) WriteImage(w io.Writer) error {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for addr := 0; addr < 0x10000; addr++ {
+		var rgb [3]uint8
+		for i, flag := range []uint8{EXECUTED, READ, WRITTEN} {
+			switch {
+			case m.Play[addr]&flag != 0:
+				rgb[i] = 0xFF
+			case m.Init[addr]&flag != 0:
+				rgb[i] = 0x80
+			}
+		}
+		img.Set(addr&0xFF, addr>>8, color.RGBA{rgb[0], rgb[1], rgb[2], 0xFF})
+	}
+	return png.Encode(w, img)
+}
+
+// PrintSummary prints the code range, the data range outside the zero
+// page, the stack and the I/O area, and the zero page addresses used
+func (m *MemoryMap) PrintSummary(w io.Writer) {
+	code := newRange()
+	data := newRange()
+	var zeroPage []string
+	for addr := 0; addr < 0x10000; addr++ {
+		flags := m.Init[addr] | m.Play[addr]
+		switch {
+		case flags == 0:
+		case flags&EXECUTED != 0:
+			code.add(addr)
+		case addr < 0x100:
+			zeroPage = append(zeroPage, fmt.Sprintf("$%02X", addr))
+		case addr < 0x200 || (addr >= 0xD000 && addr < 0xE000):
+		default:
+			data.add(addr)
+		}
+	}
+	fmt.Fprintf(w, "Code: %s\n", code)
+	fmt.Fprintf(w, "Data: %s\n", data)
+	if len(zeroPage) == 0 {
+		zeroPage = []string{"none"}
+	}
+	fmt.Fprintf(w, "Zero page: %s\n", strings.Join(zeroPage, " "))
+}
+
+// addressRange is the span of a set of addresses
+type addressRange struct {
+	first, last, count int
+}
+
+func newRange() *addressRange {
+	return &addressRange{first: -1}
+}
+
+func (r *addressRange) add(addr int) {
+	if r.first < 0 {
+		r.first = addr
+	}
+	r.last = addr
+	r.count++
+}
+
+func (r *addressRange) String() string {
+	if r.count == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("$%04X-$%04X (%d bytes used)", r.first, r.last, r.count)
+}
@@ -0,0 +1,132 @@
+package debugger
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"siddump/c64"
+
+	"github.com/beevik/go6502/cpu"
+	"github.com/beevik/go6502/disasm"
+)
+
+// FrameSet selects the routine calls to trace, e.g. "init,0-99,500"
+type FrameSet struct {
+	Init   bool
+	Ranges [][2]int
+}
+
+// ParseFrameSet parses a comma separated list of "init", frame numbers
+// and frame ranges. An empty list selects init and all frames.
+func ParseFrameSet(list string) (*FrameSet, error) {
+	if list == "" {
+		return &FrameSet{Init: true, Ranges: [][2]int{{0, -1}}}, nil
+	}
+	set := &FrameSet{}
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "init" {
+			set.Init = true
+			continue
+		}
+		first, last, isRange := strings.Cut(item, "-")
+		from, err := strconv.Atoi(first)
+		to := from
+		if err == nil && isRange {
+			to, err = strconv.Atoi(last)
+		}
+		if err != nil || from < 0 || to < from {
+			return nil, fmt.Errorf("bad frame range %q", item)
+		}
+		set.Ranges = append(set.Ranges, [2]int{from, to})
+	}
+	return set, nil
+}
+
+// Contains reports whether a call of a routine is selected. A range ending
+// at -1 is open.
+func (set *FrameSet) Contains(routine string, frame int) bool {
+	if routine == "init" {
+		return set.Init
+	}
+	for _, r := range set.Ranges {
+		if frame >= r[0] && (frame <= r[1] || r[1] < 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tracer logs every instruction executed by the selected init and play
+// routine calls: cycle, PC, instruction, registers and memory accesses.
+// The registers are the ones before the instruction executes.
+type Tracer struct {
+	Out    io.Writer
+	Frames *FrameSet
+
+	cpu     *cpu.CPU
+	routine string
+	frame   int
+	active  bool
+	// Instruction being executed, written once its stores are known
+	line string
+	err  error
+}
+
+func NewTracer(out io.Writer, frames *FrameSet) *Tracer {
+	return &Tracer{Out: out, Frames: frames, frame: -1}
+}
+
+// Attach installs the tracer on a machine before the tune is loaded
+func (t *Tracer) Attach(c *cpu.CPU) {
+	t.cpu = c
+	c64.OnStep(c, t.step)
+	c64.OnStore(c, t.store)
+}
+
+func (t *Tracer) step(c *cpu.CPU, routine string, frame int) error {
+	if err := t.Flush(); err != nil {
+		return err
+	}
+	if routine != t.routine || frame != t.frame {
+		t.routine, t.frame = routine, frame
+		t.active = t.Frames.Contains(routine, frame)
+		if t.active {
+			t.write(fmt.Sprintf("; %s routine, frame %d", routine, frame))
+		}
+	}
+	if !t.active {
+		return nil
+	}
+
+	inst, _ := disasm.Disassemble(c, c.Reg.PC, disasm.ShowAddress|disasm.ShowCode|disasm.ShowInstruction, "", nil)
+	t.line = fmt.Sprintf("%10d  %s %s SP=%02X", c.Cycles, inst, disasm.GetCompactRegisterString(&c.Reg), c.Reg.SP)
+	for _, addr := range c64.Reads(c) {
+		t.line += fmt.Sprintf(" R:$%04X=$%02X", addr, c.Mem.LoadByte(addr))
+	}
+	return nil
+}
+
+func (t *Tracer) store(addr uint16, v byte) {
+	if t.line != "" {
+		t.line += fmt.Sprintf(" W:$%04X=$%02X", addr, v)
+	}
+}
+
+func (t *Tracer) write(line string) {
+	if _, err := fmt.Fprintln(t.Out, line); err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+// Flush writes the last traced instruction and returns the first write
+// error
+func (t *Tracer) Flush() error {
+	if t.line != "" {
+		t.write(t.line)
+		t.line = ""
+	}
+	return t.err
+}
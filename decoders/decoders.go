@@ -1,76 +1,178 @@
-package main
+package decoders
 
 import (
 	"encoding/binary"
 	"fmt"
-	"log"
-	"os"
+	"io"
 	"strings"
+	"time"
+
+	"siddump/psid"
+	"siddump/sid"
 )
 
 // define a commmon interface for all output decoders
 type SidOutputDecoder interface {
-	PreSteps()
-	ProcessFrame(frame int, cycles uint64)
-	PostSteps()
+	PreSteps() error
+	ProcessFrame(frame int, cycles uint64) error
+	PostSteps() error
+}
+
+// TimedDecoder is a decoder using the playback time of the frames.
+// SetTime is called before ProcessFrame with the time at the start of the
+// frame and its duration, as worked out by the player.
+type TimedDecoder interface {
+	SetTime(t time.Duration, duration time.Duration)
+}
+
+// DecoderError reports a failing output decoder step
+type DecoderError struct {
+	Step string
+	Err  error
+}
+
+func (e *DecoderError) Error() string {
+	return fmt.Sprintf("decoder %s: %v", e.Step, e.Err)
+}
+
+func (e *DecoderError) Unwrap() error {
+	return e.Err
 }
 
+// NewDecoder creates the decoder of an output mode. The output is written
+// to out, diagnostics to log. The piano roll SVG and the register heatmap
+// are only written when their writer is set on the returned decoder.
+func NewDecoder(mode int, opt *SidOutputSettings, s *sid.Sid, header *psid.PSIDHeader, stil *psid.STILEntry, out io.Writer, log io.Writer) SidOutputDecoder {
+	switch mode {
+	case 1:
+		return &ScreenOutputSidRegisters{Options: opt, SidState: s, Out: out}
+	case 4:
+		return &BinFileRegistersAndDtDumps{Options: opt, SidState: s, Out: out}
+	case 5:
+		return &GoatTrackerSngExport{Options: opt, SidState: s, Out: out, Log: log, Header: header}
+	case 6:
+		return &XMExport{Options: opt, SidState: s, Out: out, Log: log, Header: header}
+	case 7:
+		return &PianoRollImage{Options: opt, SidState: s, Out: out, Log: log, Header: header}
+	case 8:
+		return &HTMLReport{Options: opt, SidState: s, Out: out, Log: log, Header: header, STIL: stil}
+	case 9:
+		return &RegisterStatistics{Options: opt, SidState: s, Out: out, Log: log}
+	default:
+		return &ScreenOutputWithNotes{Options: opt, SidState: s, Out: out}
+	}
+}
+
+// Extension returns the file extension of the output of a mode, or an
+// empty string for modes that print text
+func Extension(mode int) string {
+	switch mode {
+	case 4:
+		return ".dmp"
+	case 5:
+		return ".sng"
+	case 6:
+		return ".xm"
+	case 7:
+		return ".png"
+	case 8:
+		return ".html"
+	}
+	return ""
+}
+
+// ActiveDecoder feeds the frames of one emulation run to any number of
+// decoders, in the order they were added
 type ActiveDecoder struct {
-	decoder SidOutputDecoder
+	decoders []SidOutputDecoder
 }
 
+// SetOutput replaces all decoders with one
 func (d *ActiveDecoder) SetOutput(dec SidOutputDecoder) {
-	d.decoder = dec
+	d.decoders = []SidOutputDecoder{dec}
+}
+
+func (d *ActiveDecoder) AddOutput(dec SidOutputDecoder) {
+	d.decoders = append(d.decoders, dec)
+}
+
+func (d *ActiveDecoder) PreProcess() error {
+	for _, dec := range d.decoders {
+		if err := dec.PreSteps(); err != nil {
+			return &DecoderError{"setup", err}
+		}
+	}
+	return nil
 }
 
-func (d *ActiveDecoder) PreProcess() {
-	d.decoder.PreSteps()
+func (d *ActiveDecoder) ProcessFrame(frame int, cycles uint64) error {
+	for _, dec := range d.decoders {
+		if err := dec.ProcessFrame(frame, cycles); err != nil {
+			return &DecoderError{fmt.Sprintf("frame %d", frame), err}
+		}
+	}
+	return nil
 }
 
-func (d *ActiveDecoder) ProcessFrame(frame int, cycles uint64) {
-	d.decoder.ProcessFrame(frame, cycles)
+// SetTime passes the playback time of the next frame to the decoders
+// using it
+func (d *ActiveDecoder) SetTime(t time.Duration, duration time.Duration) {
+	for _, dec := range d.decoders {
+		if dec, ok := dec.(TimedDecoder); ok {
+			dec.SetTime(t, duration)
+		}
+	}
 }
 
-func (d *ActiveDecoder) PostProcess() {
-	d.decoder.PostSteps()
+// PostProcess finishes all decoders, also when one of them fails
+func (d *ActiveDecoder) PostProcess() error {
+	var first error
+	for _, dec := range d.decoders {
+		if err := dec.PostSteps(); err != nil && first == nil {
+			first = &DecoderError{"finish", err}
+		}
+	}
+	return first
 }
 
 type ScreenOutputWithNotes struct {
 	Options  *SidOutputSettings
-	SidState *Sid
+	SidState *sid.Sid
+	Out      io.Writer
 
-	prevSidState [2]*Sid
+	prevSidState [2]*sid.Sid
 	counter      int
 	rows         int
 }
 
 // use struct to implement interface
-func (state *ScreenOutputWithNotes) PreSteps() {
-	state.prevSidState[0] = NewSID()
-	state.prevSidState[1] = NewSID()
+func (state *ScreenOutputWithNotes) PreSteps() error {
+	state.prevSidState[0] = sid.NewSID()
+	state.prevSidState[1] = sid.NewSID()
 
-	fmt.Printf("Middle C frequency is $%04X\n\n", uint16(freqtbllo[48])|(uint16(freqtblhi[48])<<8))
-	fmt.Printf("| Frame | Freq Note/Abs WF ADSR Pul | Freq Note/Abs WF ADSR Pul | Freq Note/Abs WF ADSR Pul | FCut RC Typ V |")
+	fmt.Fprintf(state.Out, "Middle C frequency is $%04X\n\n", uint16(freqtbllo[48])|(uint16(freqtblhi[48])<<8))
+	fmt.Fprintf(state.Out, "| Frame | Freq Note/Abs WF ADSR Pul | Freq Note/Abs WF ADSR Pul | Freq Note/Abs WF ADSR Pul | FCut RC Typ V |")
 
 	if state.Options.Profiling != 0 {
 		// CPU cycles, Raster lines, Raster lines with badlines on every 8th line, first line included
-		fmt.Printf(" Cycl RL RB |")
+		fmt.Fprintf(state.Out, " Cycl RL RB |")
 	}
-	fmt.Printf("\n")
-	fmt.Printf("+-------+---------------------------+---------------------------+---------------------------+---------------+")
+	fmt.Fprintf(state.Out, "\n")
+	fmt.Fprintf(state.Out, "+-------+---------------------------+---------------------------+---------------------------+---------------+")
 	if state.Options.Profiling != 0 {
-		fmt.Printf("------------+")
+		fmt.Fprintf(state.Out, "------------+")
 	}
-	fmt.Printf("\n")
+	fmt.Fprintf(state.Out, "\n")
 
 	// Check other parameters for correctness
 	if ((state.Options.Lowres == 1) && (state.Options.Spacing == 0)) {
 		state.Options.Lowres = 0
 	}
 
+	return nil
 }
 
-func (state *ScreenOutputWithNotes) ProcessFrame(frame int, cycles uint64) {
+func (state *ScreenOutputWithNotes) ProcessFrame(frame int, cycles uint64) error {
 	var sb strings.Builder
 
 	opt := state.Options
@@ -100,25 +202,12 @@ func (state *ScreenOutputWithNotes) ProcessFrame(frame int, cycles uint64) {
 
 		// Frequency
 		if (firstframe) || (prevSid.Channel[i].Note == -1) || (currentSid.Channel[i].Freq != prevSid.Channel[i].Freq) {
-			dist := 0x7fffffff
 			delta := int(currentSid.Channel[i].Freq) - int(prev2Sid.Channel[i].Freq)
 			sb.WriteString(fmt.Sprintf("%04X ", currentSid.Channel[i].Freq))
 
 			if currentSid.Channel[i].Wave >= 0x10 {
 				// Get new note number
-				for d := 0; d < 96; d++ {
-					cmpfreq := uint16(freqtbllo[d]) | (uint16(freqtblhi[d]) << 8)
-					freq := currentSid.Channel[i].Freq
-
-					if absInt(int(freq)-int(cmpfreq)) < dist {
-						dist = absInt(int(freq) - int(cmpfreq))
-						// favor old note
-						if d == prevSid.Channel[i].Note {
-							dist /= opt.Oldnotefactor
-						}
-						currentSid.Channel[i].Note = d
-					}
-				}
+				currentSid.Channel[i].Note = findNote(currentSid.Channel[i].Freq, prevSid.Channel[i].Note, opt.Oldnotefactor)
 
 				// Print new note
 				curr_note := currentSid.Channel[i].Note
@@ -215,10 +304,10 @@ func (state *ScreenOutputWithNotes) ProcessFrame(frame int, cycles uint64) {
 
 	switch {
 		case opt.Lowres != 0, opt.Spacing == 0:
-			fmt.Print(sb.String())
+			fmt.Fprint(state.Out, sb.String())
 			prevSid.CopyFrom(currentSid)
 		case (frame - opt.Firstframe) % opt.Spacing == 0:
-			fmt.Print(sb.String())
+			fmt.Fprint(state.Out, sb.String())
 			prevSid.CopyFrom(currentSid)
 	}
 
@@ -226,55 +315,60 @@ func (state *ScreenOutputWithNotes) ProcessFrame(frame int, cycles uint64) {
 
 	// Print note/pattern separators, if needed
 	if opt.Spacing == 0 {
-		return
+		return nil
 	}
 
 	state.counter++
 
 	if state.counter < opt.Spacing {
-		return
+		return nil
 	}
 
 	state.counter = 0
 
 	if opt.Pattspacing == 0 {
 		if opt.Lowres != 0 {
-			fmt.Printf("+-------+---------------------------+---------------------------+---------------------------+---------------+\n")
+			fmt.Fprintf(state.Out, "+-------+---------------------------+---------------------------+---------------------------+---------------+\n")
 		}
-		return
+		return nil
 	}
 
 	state.rows++
 	if state.rows >= opt.Pattspacing {
 		state.rows = 0
-		fmt.Printf("+=======+===========================+===========================+===========================+===============+\n")
-		return
+		fmt.Fprintf(state.Out, "+=======+===========================+===========================+===========================+===============+\n")
+		return nil
 	}
 
 	if opt.Lowres != 0 {
-		fmt.Printf("+-------+---------------------------+---------------------------+---------------------------+---------------+\n")
+		fmt.Fprintf(state.Out, "+-------+---------------------------+---------------------------+---------------------------+---------------+\n")
 	}
+	return nil
 }
 
-func (state *ScreenOutputWithNotes) PostSteps() {}
+func (state *ScreenOutputWithNotes) PostSteps() error {
+	return nil
+}
 
 // struct to implement decoder for screen output with notes
 // info.
 type ScreenOutputSidRegisters struct {
 	Options  *SidOutputSettings
-	SidState *Sid
+	SidState *sid.Sid
+	Out      io.Writer
 
-	prevSidState *Sid
+	prevSidState *sid.Sid
 }
 
-func (state *ScreenOutputSidRegisters) PreSteps() {
-	state.prevSidState = NewSID()
-	fmt.Printf("| Frame | 00 01 02 03 04 05 06 | 07 08 09 10 11 12 13 | 14 15 16 17 18 19 20 | 21 22 23 24 | dt_us |")
-	fmt.Printf("\n")
-	fmt.Printf("+-------+----+-----------------+----------------------+----------------------+-------------+-------+")
-	fmt.Printf("\n")
+func (state *ScreenOutputSidRegisters) PreSteps() error {
+	state.prevSidState = sid.NewSID()
+	fmt.Fprintf(state.Out, "| Frame | 00 01 02 03 04 05 06 | 07 08 09 10 11 12 13 | 14 15 16 17 18 19 20 | 21 22 23 24 | dt_us |")
+	fmt.Fprintf(state.Out, "\n")
+	fmt.Fprintf(state.Out, "+-------+----+-----------------+----------------------+----------------------+-------------+-------+")
+	fmt.Fprintf(state.Out, "\n")
+	return nil
 }
-func (state *ScreenOutputSidRegisters) ProcessFrame(frame int, cycles uint64) {
+func (state *ScreenOutputSidRegisters) ProcessFrame(frame int, cycles uint64) error {
 	var sb strings.Builder
 
 	opt := state.Options
@@ -304,36 +398,28 @@ func (state *ScreenOutputSidRegisters) ProcessFrame(frame int, cycles uint64) {
 	}
 	sb.WriteString(fmt.Sprintf("|  %04X ", (uint16(currentSid.Register[25])<<8)|uint16(currentSid.Register[26])))
 	sb.WriteString("|\n")
-	fmt.Print(sb.String())
+	fmt.Fprint(state.Out, sb.String())
+	return nil
 }
 
-func (state *ScreenOutputSidRegisters) PostSteps() {}
+func (state *ScreenOutputSidRegisters) PostSteps() error {
+	return nil
+}
 
 type BinFileRegistersAndDtDumps struct {
 	Options  *SidOutputSettings
-	SidState *Sid
-
-	fileName   string
-	fileHandle *os.File
+	SidState *sid.Sid
+	Out      io.Writer
 }
 
-func (state *BinFileRegistersAndDtDumps) PreSteps() {
-	var err error
-	// state.fileName = "sidtune.dmp"
-	state.fileHandle, err = os.Create(state.fileName)
-	check(err)
+func (state *BinFileRegistersAndDtDumps) PreSteps() error {
+	return nil
 }
 
-func (state *BinFileRegistersAndDtDumps) ProcessFrame(frame int, cycles uint64) {
-
-	err := binary.Write(state.fileHandle, binary.BigEndian, state.SidState.Register[:])
-
-	if err != nil {
-		log.Fatal(err)
-		state.fileHandle.Close()
-	}
+func (state *BinFileRegistersAndDtDumps) ProcessFrame(frame int, cycles uint64) error {
+	return binary.Write(state.Out, binary.BigEndian, state.SidState.Register[:])
 }
 
-func (state *BinFileRegistersAndDtDumps) PostSteps() {
-	state.fileHandle.Close()
+func (state *BinFileRegistersAndDtDumps) PostSteps() error {
+	return nil
 }
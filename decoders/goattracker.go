@@ -0,0 +1,340 @@
+package decoders
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"siddump/psid"
+	"siddump/sid"
+)
+
+// GoatTracker 2 limits
+const (
+	GT_MAX_INSTR    = 63
+	GT_MAX_PATT     = 208
+	GT_MAX_PATTROWS = 128
+	GT_MAX_SONGLEN  = 254
+	GT_MAX_TABLELEN = 255
+)
+
+// GoatTracker 2 pattern data
+const (
+	GT_FIRSTNOTE = 0x60
+	GT_LASTNOTE  = 0xBC
+	GT_REST      = 0xBD
+	GT_KEYOFF    = 0xBE
+	GT_ENDPATT   = 0xFF
+	GT_CMD_PORTA = 0x03
+	GT_CMD_TEMPO = 0x0F
+)
+
+// Number of frames of each note used to build the instrument tables
+const GT_INSTR_FRAMES = 16
+
+// GoatTracker 2 instrument, see the .sng format description in the
+// GoatTracker documentation
+type gtInstrument struct {
+	AD       uint8
+	SR       uint8
+	Wave     uint8
+	Pulse    uint8
+	Filter   uint8
+	Vibrato  uint8
+	VibDelay uint8
+	GateOff  uint8
+	FirstWF  uint8
+	Name     [16]byte
+}
+
+// gtTable is one of the four GoatTracker tables (wave, pulse, filter, speed)
+type gtTable struct {
+	Left  []uint8
+	Right []uint8
+}
+
+func (t *gtTable) add(left uint8, right uint8) {
+	t.Left = append(t.Left, left)
+	t.Right = append(t.Right, right)
+}
+
+// struct to implement decoder that converts the dump into a
+// GoatTracker 2 song
+type GoatTrackerSngExport struct {
+	Options  *SidOutputSettings
+	SidState *sid.Sid
+	Out      io.Writer
+	Log      io.Writer
+	Header   *psid.PSIDHeader
+
+	frames  []sid.Sid
+	tracker *NoteTracker
+
+	instruments []gtInstrument
+	instrKeys   map[string]int
+	tables      [4]gtTable
+	patterns    [][]uint8
+	pattKeys    map[string]int
+	orderlist   [3][]uint8
+}
+
+func (state *GoatTrackerSngExport) PreSteps() error {
+	state.tracker = NewNoteTracker(state.Options.Oldnotefactor)
+	state.instrKeys = make(map[string]int)
+	state.pattKeys = make(map[string]int)
+	return nil
+}
+
+func (state *GoatTrackerSngExport) ProcessFrame(frame int, cycles uint64) error {
+	state.frames = append(state.frames, *state.SidState)
+	state.tracker.Track(state.SidState)
+	return nil
+}
+
+func (state *GoatTrackerSngExport) PostSteps() error {
+	state.tracker.Finish()
+
+	// Frames per row from -n, tempos below 3 have a special meaning in
+	// GoatTracker
+	speed := state.Options.Spacing
+	if speed < 3 {
+		speed = 6
+	}
+	pattRows := state.Options.Pattspacing
+	if pattRows <= 0 || pattRows > GT_MAX_PATTROWS {
+		pattRows = 32
+	}
+
+	for i := 0; i < 3; i++ {
+		rows := state.buildRows(i, speed)
+
+		// Set the tempo on the first row of the first channel
+		if i == 0 && len(rows) > 0 {
+			rows[0][2] = GT_CMD_TEMPO
+			rows[0][3] = uint8(speed)
+		}
+
+		// Cut rows into patterns, identical blocks share one pattern
+		for r := 0; r < len(rows); r += pattRows {
+			end := r + pattRows
+			if end > len(rows) {
+				end = len(rows)
+			}
+			patt := state.addPattern(rows[r:end])
+			if patt < 0 || len(state.orderlist[i]) >= GT_MAX_SONGLEN {
+				logf(state.Log, "Warning: GoatTracker limits reached, channel %d truncated\n", i+1)
+				break
+			}
+			state.orderlist[i] = append(state.orderlist[i], uint8(patt))
+		}
+	}
+
+	w := bufio.NewWriter(state.Out)
+	state.write(w)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	logf(state.Log, "GoatTracker song: %d instruments, %d patterns, tempo %d\n", len(state.instruments), len(state.patterns), speed)
+	return nil
+}
+
+// buildRows quantizes the notes of one voice into pattern rows of speed
+// frames each.
+func (state *GoatTrackerSngExport) buildRows(voice int, speed int) [][4]uint8 {
+	numRows := (state.tracker.Frames() + speed - 1) / speed
+	rows := make([][4]uint8, numRows)
+	for r := range rows {
+		rows[r][0] = GT_REST
+	}
+
+	notes := state.tracker.Notes(voice)
+	for n, note := range notes {
+		r := note.Start / speed
+		if rows[r][0] >= GT_FIRSTNOTE && rows[r][0] < GT_REST {
+			// Only the first note within a row is kept
+			continue
+		}
+		rows[r][0] = gtNote(note.Note)
+		rows[r][1] = uint8(state.addInstrument(&notes[n]))
+		if note.Legato {
+			// Tie note, no retrigger
+			rows[r][2] = GT_CMD_PORTA
+			rows[r][3] = 0
+		}
+
+		// Key off, unless the next note follows directly
+		off := (note.End + speed - 1) / speed
+		if off < numRows && (n+1 == len(notes) || notes[n+1].Start > note.End) && rows[off][0] == GT_REST {
+			rows[off][0] = GT_KEYOFF
+		}
+	}
+	return rows
+}
+
+// gtNote returns the pattern byte of a note. GoatTracker has no notes above
+// $BC, higher ones are moved down by octaves.
+func gtNote(note int) uint8 {
+	for GT_FIRSTNOTE+note > GT_LASTNOTE {
+		note -= 12
+	}
+	return uint8(GT_FIRSTNOTE + note)
+}
+
+// addInstrument infers an instrument from the first frames of a note and
+// returns its number. Notes that behave identically share an instrument.
+func (state *GoatTrackerSngExport) addInstrument(note *NoteEvent) int {
+	end := note.End
+	if end > note.Start+GT_INSTR_FRAMES {
+		end = note.Start + GT_INSTR_FRAMES
+	}
+
+	var wave gtTable
+	var pulse gtTable
+	usesPulse := false
+	prevNote := note.Note
+	for f := note.Start; f < end; f++ {
+		v := &state.frames[f].Channel[note.Voice]
+		wf := v.Wave
+		switch {
+		case wf < 0x10:
+			// Inaudible waveforms
+			wf |= 0xE0
+		case wf >= 0xE0:
+			wf = 0x80 | (wf & 0x0F)
+		}
+
+		// Relative note, positive $00-$5F, negative $60-$7F
+		prevNote = findNote(v.Freq, prevNote, state.Options.Oldnotefactor)
+		rel := prevNote - note.Note
+		switch {
+		case rel < -32:
+			rel = -32
+		case rel > 0x5F:
+			rel = 0x5F
+		}
+		wave.add(wf, uint8(rel)&0x7F)
+
+		if v.Wave&0x40 != 0 {
+			usesPulse = true
+		}
+	}
+	wave.add(0xFF, 0x00)
+
+	if usesPulse {
+		first := state.frames[note.Start].Channel[note.Voice].Pulse
+		pulse.add(0x80|uint8(first>>8), uint8(first))
+		if note.Start+1 < end {
+			delta := int(state.frames[note.Start+1].Channel[note.Voice].Pulse) - int(first)
+			if delta > 127 {
+				delta = 127
+			}
+			if delta < -128 {
+				delta = -128
+			}
+			if delta != 0 {
+				pulse.add(0x7F, uint8(int8(delta)))
+			}
+		}
+		pulse.add(0xFF, 0x00)
+	}
+
+	adsr := state.frames[note.Start].Channel[note.Voice].ADSR
+	key := fmt.Sprintf("%04X %X %X %X %X", adsr, wave.Left, wave.Right, pulse.Left, pulse.Right)
+	if instr, ok := state.instrKeys[key]; ok {
+		return instr
+	}
+
+	if len(state.instruments) >= GT_MAX_INSTR ||
+		len(state.tables[0].Left)+len(wave.Left) > GT_MAX_TABLELEN ||
+		len(state.tables[1].Left)+len(pulse.Left) > GT_MAX_TABLELEN {
+		// Out of room, fall back to the first instrument
+		return 1
+	}
+
+	instr := gtInstrument{
+		AD:      uint8(adsr >> 8),
+		SR:      uint8(adsr),
+		Wave:    state.appendTable(0, &wave),
+		GateOff: 0x02,
+		FirstWF: state.frames[note.Start].Channel[note.Voice].Wave,
+	}
+	if usesPulse {
+		instr.Pulse = state.appendTable(1, &pulse)
+	}
+	copy(instr.Name[:], fmt.Sprintf("ADSR %04X", adsr))
+
+	state.instruments = append(state.instruments, instr)
+	state.instrKeys[key] = len(state.instruments)
+	return len(state.instruments)
+}
+
+// appendTable appends rows to one of the tables and returns the 1-based
+// table pointer. Jumps in the appended rows are relocated.
+func (state *GoatTrackerSngExport) appendTable(table int, rows *gtTable) uint8 {
+	t := &state.tables[table]
+	pos := uint8(len(t.Left) + 1)
+	for r := range rows.Left {
+		right := rows.Right[r]
+		if rows.Left[r] == 0xFF && right != 0 {
+			right += pos - 1
+		}
+		t.add(rows.Left[r], right)
+	}
+	return pos
+}
+
+// addPattern stores a block of rows as a pattern and returns its number,
+// or -1 when there are no free patterns.
+func (state *GoatTrackerSngExport) addPattern(rows [][4]uint8) int {
+	data := make([]uint8, 0, (len(rows)+1)*4)
+	for _, row := range rows {
+		data = append(data, row[:]...)
+	}
+	data = append(data, GT_ENDPATT, 0, 0, 0)
+
+	key := string(data)
+	if patt, ok := state.pattKeys[key]; ok {
+		return patt
+	}
+	if len(state.patterns) >= GT_MAX_PATT {
+		return -1
+	}
+	state.patterns = append(state.patterns, data)
+	state.pattKeys[key] = len(state.patterns) - 1
+	return len(state.patterns) - 1
+}
+
+func (state *GoatTrackerSngExport) write(w *bufio.Writer) {
+	w.WriteString("GTS5")
+	w.Write(state.Header.Name[:])
+	w.Write(state.Header.Author[:])
+	w.Write(state.Header.Released[:])
+
+	// One subtune, orderlists end with $FF and restart position 0
+	w.WriteByte(1)
+	for i := 0; i < 3; i++ {
+		w.WriteByte(uint8(len(state.orderlist[i]) + 1))
+		w.Write(state.orderlist[i])
+		w.WriteByte(0xFF)
+		w.WriteByte(0x00)
+	}
+
+	w.WriteByte(uint8(len(state.instruments)))
+	for _, instr := range state.instruments {
+		binary.Write(w, binary.BigEndian, &instr)
+	}
+
+	for _, t := range state.tables {
+		w.WriteByte(uint8(len(t.Left)))
+		w.Write(t.Left)
+		w.Write(t.Right)
+	}
+
+	w.WriteByte(uint8(len(state.patterns)))
+	for _, patt := range state.patterns {
+		w.WriteByte(uint8(len(patt) / 4))
+		w.Write(patt)
+	}
+}
@@ -0,0 +1,345 @@
+package decoders
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"siddump/psid"
+	"siddump/sid"
+)
+
+// Number of frames summed up in one heatmap column
+const HEATMAP_FRAMES = 50
+
+type reportField struct {
+	Name  string
+	Value string
+}
+
+type reportVoice struct {
+	Voice    int
+	Notes    int
+	Lowest   string
+	Highest  string
+	Gated    string
+	Filtered string
+	Waves    string
+	ADSRs    string
+}
+
+type reportCell struct {
+	Value   string
+	Changed bool
+}
+
+type reportFrame struct {
+	Time  string
+	Cells []reportCell
+}
+
+type reportHeat struct {
+	Name  string
+	Cells []int
+}
+
+type reportData struct {
+	Title     string
+	Header    []reportField
+	Voices    []reportVoice
+	PianoRoll template.HTML
+	Heat      []reportHeat
+	TimeName  string
+	Columns   []string
+	Frames    []reportFrame
+}
+
+// struct to implement decoder that writes a self-contained HTML report
+type HTMLReport struct {
+	Options  *SidOutputSettings
+	SidState *sid.Sid
+	Out      io.Writer
+	Log      io.Writer
+	Header   *psid.PSIDHeader
+	STIL     *psid.STILEntry
+
+	frames  []sid.Sid
+	numbers []int
+	cycles  []uint64
+	times   []time.Duration
+	time    time.Duration
+	tracker *NoteTracker
+}
+
+func (state *HTMLReport) PreSteps() error {
+	state.tracker = NewNoteTracker(state.Options.Oldnotefactor)
+	return nil
+}
+
+// SetTime sets the playback time of the next frame
+func (state *HTMLReport) SetTime(t time.Duration, duration time.Duration) {
+	state.time = t
+}
+
+func (state *HTMLReport) ProcessFrame(frame int, cycles uint64) error {
+	state.frames = append(state.frames, *state.SidState)
+	state.numbers = append(state.numbers, frame)
+	state.cycles = append(state.cycles, cycles)
+	state.times = append(state.times, state.time)
+	state.tracker.Track(state.SidState)
+	return nil
+}
+
+func (state *HTMLReport) PostSteps() error {
+	state.tracker.Finish()
+
+	data := &reportData{Title: psid.CString(state.Header.Name[:])}
+	state.addHeader(data)
+	state.addVoices(data)
+	state.addPianoRoll(data)
+	state.addHeatmap(data)
+	state.addFrames(data)
+
+	w := bufio.NewWriter(state.Out)
+	if err := reportTemplate.Execute(w, data); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	logf(state.Log, "HTML report: %d frames\n", len(state.frames))
+	return nil
+}
+
+func (state *HTMLReport) addHeader(data *reportData) {
+	h := state.Header
+	data.Header = []reportField{
+		{"Name", psid.CString(h.Name[:])},
+		{"Author", psid.CString(h.Author[:])},
+		{"Released", psid.CString(h.Released[:])},
+		{"Format", fmt.Sprintf("%s v%d", h.MagicID[:], h.Version)},
+		{"Load address", fmt.Sprintf("$%04X", h.LoadAddress)},
+		{"Init address", fmt.Sprintf("$%04X", h.InitAddress)},
+		{"Play address", fmt.Sprintf("$%04X", h.PlayAddress)},
+		{"Songs", fmt.Sprintf("%d (start song %d)", h.Songs, h.StartSong)},
+		{"Speed", fmt.Sprintf("$%08X", h.Speed)},
+		{"Subtune", fmt.Sprintf("%d", state.Options.Subtune)},
+		{"Frames", fmt.Sprintf("%d, starting from frame %d", len(state.frames), state.Options.Firstframe)},
+	}
+
+	if state.STIL != nil {
+		data.Header = append(data.Header, reportField{"STIL", state.STIL.Path})
+		for _, f := range state.STIL.Fields(state.Options.Subtune + 1) {
+			data.Header = append(data.Header, reportField{"STIL " + strings.ToLower(f.Name), f.Value})
+		}
+	}
+}
+
+func (state *HTMLReport) addVoices(data *reportData) {
+	total := len(state.frames)
+	if total == 0 {
+		total = 1
+	}
+
+	for i := 0; i < 3; i++ {
+		voice := reportVoice{Voice: i + 1, Lowest: "-", Highest: "-"}
+
+		notes := state.tracker.Notes(i)
+		voice.Notes = len(notes)
+		low, high := -1, -1
+		for _, n := range notes {
+			if low < 0 || n.Note < low {
+				low = n.Note
+			}
+			if n.Note > high {
+				high = n.Note
+			}
+		}
+		if low >= 0 {
+			voice.Lowest = notename[low]
+			voice.Highest = notename[high]
+		}
+
+		gated, filtered := 0, 0
+		waves := make(map[uint8]bool)
+		adsrs := make(map[uint16]bool)
+		for f := range state.frames {
+			v := &state.frames[f].Channel[i]
+			if isSounding(v) {
+				gated++
+				waves[v.Wave&0xFE] = true
+				adsrs[v.ADSR] = true
+			}
+			if state.frames[f].Register[23]&(1<<i) != 0 {
+				filtered++
+			}
+		}
+		voice.Gated = fmt.Sprintf("%d%%", gated*100/total)
+		voice.Filtered = fmt.Sprintf("%d%%", filtered*100/total)
+		voice.Waves = sortedHex(waves, "%02X")
+		voice.ADSRs = sortedHex(adsrs, "%04X")
+
+		data.Voices = append(data.Voices, voice)
+	}
+}
+
+// sortedHex lists the keys of a set in ascending order
+func sortedHex[T uint8 | uint16](set map[T]bool, format string) string {
+	keys := make([]int, 0, len(set))
+	for k := range set {
+		keys = append(keys, int(k))
+	}
+	sort.Ints(keys)
+
+	s := make([]string, len(keys))
+	for i, k := range keys {
+		s[i] = fmt.Sprintf(format, k)
+	}
+	return strings.Join(s, " ")
+}
+
+func (state *HTMLReport) addPianoRoll(data *reportData) {
+	if len(state.frames) == 0 {
+		return
+	}
+	roll := &PianoRollImage{Options: state.Options, SidState: state.SidState, Header: state.Header, frames: state.frames}
+	height := roll.layout()
+
+	var sb strings.Builder
+	roll.renderSVG(&sb, len(state.frames), height)
+	data.PianoRoll = template.HTML(sb.String())
+}
+
+func (state *HTMLReport) addHeatmap(data *reportData) {
+	cols := (len(state.frames) + HEATMAP_FRAMES - 1) / HEATMAP_FRAMES
+
+	for r := 0; r < 25; r++ {
+		heat := reportHeat{Name: registerNames[r], Cells: make([]int, cols)}
+		changes := make([]int, cols)
+		for f := 1; f < len(state.frames); f++ {
+			if state.frames[f].Register[r] != state.frames[f-1].Register[r] {
+				changes[f/HEATMAP_FRAMES]++
+			}
+		}
+		// Intensity classes h0-h9
+		for c := range changes {
+			heat.Cells[c] = (changes[c]*9 + HEATMAP_FRAMES - 1) / HEATMAP_FRAMES
+		}
+		data.Heat = append(data.Heat, heat)
+	}
+}
+
+func (state *HTMLReport) addFrames(data *reportData) {
+	opt := state.Options
+	data.Columns = append(data.Columns, registerNames...)
+	data.Columns = append(data.Columns, "dt", "Cycles")
+	data.TimeName = "Frame"
+	if opt.Timeseconds != 0 {
+		data.TimeName = "Time"
+	}
+
+	for f := range state.frames {
+		cur := &state.frames[f]
+		row := reportFrame{Time: fmt.Sprintf("%d", state.numbers[f])}
+		if opt.Timeseconds != 0 {
+			// Minutes, seconds and hundredths
+			cs := int(state.times[f] / (10 * time.Millisecond))
+			row.Time = fmt.Sprintf("%01d:%02d.%02d", cs/6000, (cs/100)%60, cs%100)
+		}
+
+		for r := 0; r < 25; r++ {
+			changed := f == 0 || cur.Register[r] != state.frames[f-1].Register[r]
+			row.Cells = append(row.Cells, reportCell{fmt.Sprintf("%02X", cur.Register[r]), changed})
+		}
+		dt := (uint16(cur.Register[25]) << 8) | uint16(cur.Register[26])
+		row.Cells = append(row.Cells, reportCell{fmt.Sprintf("%04X", dt), f == 0})
+		row.Cells = append(row.Cells, reportCell{fmt.Sprintf("%d", state.cycles[f]), false})
+
+		data.Frames = append(data.Frames, row)
+	}
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; background: #fafafa; color: #202020; margin: 1em 2em; }
+h2 { margin-top: 1.5em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 6px; border: 1px solid #d0d0d0; }
+th { background: #e8e8e8; text-align: left; }
+.mono td { font-family: monospace; }
+.scroll { overflow: auto; max-width: 100%; border: 1px solid #d0d0d0; }
+#roll svg { display: block; }
+#frames { max-height: 40em; }
+#frames th { position: sticky; top: 0; z-index: 1; }
+#frames td:first-child, #frames th:first-child { position: sticky; left: 0; background: #e8e8e8; }
+#frames td.c { background: #ffe080; font-weight: bold; }
+#frames td { color: #a0a0a0; }
+#frames td.c, #frames td:first-child { color: #202020; }
+.heat td { width: 8px; height: 12px; padding: 0; }
+.heat th { font-family: monospace; font-weight: normal; padding: 0 6px; }
+.h0 { background: #ffffff; } .h1 { background: #fff0e0; } .h2 { background: #ffe0c0; }
+.h3 { background: #ffd0a0; } .h4 { background: #ffc080; } .h5 { background: #ffa060; }
+.h6 { background: #ff8040; } .h7 { background: #f06020; } .h8 { background: #e04010; }
+.h9 { background: #c02000; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+
+<h2>Header</h2>
+<table>
+{{range .Header}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+
+<h2>Voices</h2>
+<table class="mono">
+<tr><th>Voice</th><th>Notes</th><th>Lowest</th><th>Highest</th><th>Gated</th><th>Filtered</th><th>Waveforms</th><th>ADSR</th></tr>
+{{range .Voices}}<tr><td>{{.Voice}}</td><td>{{.Notes}}</td><td>{{.Lowest}}</td><td>{{.Highest}}</td><td>{{.Gated}}</td><td>{{.Filtered}}</td><td>{{.Waves}}</td><td>{{.ADSRs}}</td></tr>
+{{end}}</table>
+
+<h2>Piano roll</h2>
+<p>Zoom <input type="range" id="zoom" min="1" max="8" value="1"> Hover over notes for details.</p>
+<div class="scroll" id="roll">{{.PianoRoll}}</div>
+
+<h2>Register changes per second</h2>
+<div class="scroll">
+<table class="heat">
+{{range .Heat}}<tr><th>{{.Name}}</th>{{range .Cells}}<td class="h{{.}}"></td>{{end}}</tr>
+{{end}}</table>
+</div>
+
+<h2>Frames</h2>
+<div class="scroll" id="frames">
+<table class="mono">
+<thead><tr><th>{{.TimeName}}</th>{{range .Columns}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Frames}}<tr><td>{{.Time}}</td>{{range .Cells}}<td{{if .Changed}} class="c"{{end}}>{{.Value}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+</div>
+
+<script>
+(function() {
+	var svg = document.querySelector("#roll svg");
+	var zoom = document.getElementById("zoom");
+	if (!svg) return;
+	var w = svg.getAttribute("width"), h = svg.getAttribute("height");
+	svg.setAttribute("viewBox", "0 0 " + w + " " + h);
+	svg.setAttribute("preserveAspectRatio", "none");
+	zoom.addEventListener("input", function() {
+		svg.setAttribute("width", w * zoom.value);
+	});
+})();
+</script>
+</body>
+</html>
+`))
@@ -1,4 +1,4 @@
-package main
+package decoders
 
 // Human readable strings, notes
 var notename = []string{
@@ -17,6 +17,14 @@ var filtername = []string{
 	"Off", "Low", "Bnd", "L+B", "Hi ", "L+H", "B+H", "LBH",
 }
 
+// Human readable strings, SID registers
+var registerNames = []string{
+	"FreqLo1", "FreqHi1", "PwLo1", "PwHi1", "Ctrl1", "AD1", "SR1",
+	"FreqLo2", "FreqHi2", "PwLo2", "PwHi2", "Ctrl2", "AD2", "SR2",
+	"FreqLo3", "FreqHi3", "PwLo3", "PwHi3", "Ctrl3", "AD3", "SR3",
+	"FcLo", "FcHi", "ResFilt", "ModeVol",
+}
+
 // Lookup table for freq, low
 var freqtbllo = []uint8{
 	0x17, 0x27, 0x39, 0x4b, 0x5f, 0x74, 0x8a, 0xa1, 0xba, 0xd4, 0xf0, 0x0e,
@@ -0,0 +1,143 @@
+package decoders
+
+import (
+	"siddump/sid"
+)
+
+// Number of consecutive frames a new pitch must be held on a gated voice
+// before it is treated as a legato note rather than an arpeggio or vibrato.
+const LEGATO_HOLD_FRAMES = 3
+
+// NoteEvent is a note detected on one voice. Start and End are frame
+// numbers relative to the first processed frame, End is exclusive.
+type NoteEvent struct {
+	Voice  int
+	Start  int
+	End    int
+	Note   int
+	Legato bool
+}
+
+// NoteTracker follows the gate and frequency of each voice frame by frame
+// and turns them into note events.
+type NoteTracker struct {
+	Oldnotefactor int
+
+	notes      [3][]NoteEvent
+	active     [3]int
+	prevNote   [3]int
+	candNote   [3]int
+	candStart  [3]int
+	candFrames [3]int
+	frame      int
+}
+
+func NewNoteTracker(oldnotefactor int) *NoteTracker {
+	t := &NoteTracker{Oldnotefactor: oldnotefactor}
+	for i := 0; i < 3; i++ {
+		t.active[i] = -1
+		t.prevNote[i] = -1
+	}
+	return t
+}
+
+// findNote returns the note whose frequency is closest to freq, favoring
+// oldNote by oldnotefactor.
+func findNote(freq uint16, oldNote int, oldnotefactor int) int {
+	note := 0
+	dist := 0x7fffffff
+
+	if oldnotefactor < 1 {
+		oldnotefactor = 1
+	}
+
+	for d := 0; d < 96; d++ {
+		cmpfreq := uint16(freqtbllo[d]) | (uint16(freqtblhi[d]) << 8)
+
+		if absInt(int(freq)-int(cmpfreq)) < dist {
+			dist = absInt(int(freq) - int(cmpfreq))
+			// favor old note
+			if d == oldNote {
+				dist /= oldnotefactor
+			}
+			note = d
+		}
+	}
+	return note
+}
+
+// isSounding reports whether a voice has its gate set with an audible
+// waveform selected.
+func isSounding(v *sid.Voice) bool {
+	return v.Wave&1 == 1 && v.Wave >= 0x10
+}
+
+// Track processes the next frame of SID state.
+func (t *NoteTracker) Track(s *sid.Sid) {
+	for i := 0; i < 3; i++ {
+		v := &s.Channel[i]
+		act := t.active[i]
+
+		if !isSounding(v) {
+			if act >= 0 {
+				t.notes[i][act].End = t.frame
+				t.active[i] = -1
+			}
+			t.candFrames[i] = 0
+			continue
+		}
+
+		note := findNote(v.Freq, t.prevNote[i], t.Oldnotefactor)
+		t.prevNote[i] = note
+
+		if act < 0 {
+			t.start(i, t.frame, note, false)
+			continue
+		}
+
+		// Pitch changes on a gated voice only count as new notes when
+		// they are held long enough
+		switch {
+		case note == t.notes[i][act].Note:
+			t.candFrames[i] = 0
+		case t.candFrames[i] > 0 && note == t.candNote[i]:
+			t.candFrames[i]++
+		default:
+			t.candNote[i] = note
+			t.candStart[i] = t.frame
+			t.candFrames[i] = 1
+		}
+
+		if t.candFrames[i] >= LEGATO_HOLD_FRAMES {
+			t.notes[i][act].End = t.candStart[i]
+			t.start(i, t.candStart[i], note, true)
+		}
+	}
+	t.frame++
+}
+
+func (t *NoteTracker) start(voice int, frame int, note int, legato bool) {
+	t.notes[voice] = append(t.notes[voice], NoteEvent{Voice: voice, Start: frame, End: -1, Note: note, Legato: legato})
+	t.active[voice] = len(t.notes[voice]) - 1
+	t.candFrames[voice] = 0
+}
+
+// Finish closes all notes still playing at the end of the dump.
+func (t *NoteTracker) Finish() {
+	for i := 0; i < 3; i++ {
+		if t.active[i] >= 0 {
+			t.notes[i][t.active[i]].End = t.frame
+			t.active[i] = -1
+		}
+	}
+}
+
+// Notes returns the notes detected on a voice, in order.
+func (t *NoteTracker) Notes(voice int) []NoteEvent {
+	return t.notes[voice]
+}
+
+// Frames returns the number of frames tracked so far.
+func (t *NoteTracker) Frames() int {
+	return t.frame
+}
@@ -0,0 +1,230 @@
+package decoders
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"siddump/psid"
+	"siddump/sid"
+)
+
+// Piano roll layout, in pixels
+const (
+	PR_NOTE_HEIGHT = 4
+	PR_NOTES       = 96
+	PR_WAVE_LANE   = 6
+	PR_LEVEL_LANE  = 34
+	PR_GAP         = 4
+)
+
+var prBackground = color.RGBA{0x20, 0x20, 0x20, 0xFF}
+var prOctave = color.RGBA{0x38, 0x38, 0x38, 0xFF}
+var prFilter = color.RGBA{0xFF, 0xFF, 0x80, 0xFF}
+var prVolume = color.RGBA{0x90, 0x90, 0x90, 0xFF}
+var prCutoff = color.RGBA{0xFF, 0xFF, 0x80, 0xFF}
+var prVoice = []color.RGBA{
+	{0xE8, 0x48, 0x48, 0xFF},
+	{0x48, 0xD0, 0x48, 0xFF},
+	{0x50, 0x80, 0xF0, 0xFF},
+}
+
+// prRect is a filled rectangle of the piano roll, with an optional
+// tooltip in the SVG output
+type prRect struct {
+	X, Y, W, H int
+	Color      color.RGBA
+	Title      string
+}
+
+// prLabel is a text annotation, only drawn in the SVG output
+type prLabel struct {
+	X, Y int
+	Text string
+}
+
+// struct to implement decoder that draws the dump as a piano roll
+type PianoRollImage struct {
+	Options  *SidOutputSettings
+	SidState *sid.Sid
+	Out      io.Writer
+	Log      io.Writer
+	Header   *psid.PSIDHeader
+
+	// SVG version of the piano roll, not written when nil
+	SVG io.Writer
+
+	frames []sid.Sid
+	rects  []prRect
+	labels []prLabel
+}
+
+func (state *PianoRollImage) PreSteps() error {
+	return nil
+}
+
+func (state *PianoRollImage) ProcessFrame(frame int, cycles uint64) error {
+	state.frames = append(state.frames, *state.SidState)
+	return nil
+}
+
+func (state *PianoRollImage) PostSteps() error {
+	width := len(state.frames)
+	if width == 0 {
+		// Nothing to draw, like the piano roll of the HTML report
+		logf(state.Log, "Piano roll: no frames, not written\n")
+		return nil
+	}
+	height := state.layout()
+
+	if err := png.Encode(state.Out, state.renderPNG(width, height)); err != nil {
+		return err
+	}
+
+	if state.SVG != nil {
+		w := bufio.NewWriter(state.SVG)
+		state.renderSVG(w, width, height)
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	logf(state.Log, "Piano roll: %dx%d\n", width, height)
+	return nil
+}
+
+// dim returns a voice colour blended halfway towards the background
+func dim(c color.RGBA) color.RGBA {
+	mix := func(a, b uint8) uint8 { return uint8((uint16(a) + uint16(b)) / 2) }
+	return color.RGBA{mix(c.R, prBackground.R), mix(c.G, prBackground.G), mix(c.B, prBackground.B), 0xFF}
+}
+
+// layout turns the recorded frames into rectangles and labels and returns
+// the image height.
+func (state *PianoRollImage) layout() int {
+	width := len(state.frames)
+	noteArea := PR_NOTES * PR_NOTE_HEIGHT
+	waveTop := noteArea + PR_GAP
+	levelTop := waveTop + 3*PR_WAVE_LANE + PR_GAP
+	height := levelTop + PR_LEVEL_LANE
+
+	// Octave lines and labels
+	for octave := 0; octave < 8; octave++ {
+		y := noteY(octave * 12)
+		state.rects = append(state.rects, prRect{0, y, width, 1, prOctave, ""})
+		state.labels = append(state.labels, prLabel{2, y - 1, notename[octave*12]})
+	}
+
+	for i := 0; i < 3; i++ {
+		var start, prevNote int
+		var prevColor color.RGBA
+		var prevFiltered bool
+		prevNote = -1
+
+		// Merge runs of frames playing the same note into one bar
+		flush := func(end int) {
+			if prevNote < 0 || end <= start {
+				return
+			}
+			y := noteY(prevNote) - PR_NOTE_HEIGHT + 1
+			title := fmt.Sprintf("Voice %d %s, frames %d-%d", i+1, notename[prevNote], start, end-1)
+			state.rects = append(state.rects, prRect{start, y, end - start, PR_NOTE_HEIGHT, prevColor, title})
+			if prevFiltered {
+				state.rects = append(state.rects, prRect{start, y, end - start, 1, prFilter, ""})
+			}
+		}
+
+		note := -1
+		released := 0
+		for f := range state.frames {
+			v := &state.frames[f].Channel[i]
+			cur := -1
+			var c color.RGBA
+
+			// Notes are drawn until their release has run out
+			if v.Wave&1 == 1 {
+				released = 0
+			} else {
+				released++
+			}
+			if v.Wave >= 0x10 && released*20 <= sid.DecayMs[v.ADSR&0xF] {
+				note = findNote(v.Freq, note, state.Options.Oldnotefactor)
+				cur = note
+				c = prVoice[i]
+				if released > 0 {
+					// Gate off, note in release
+					c = dim(c)
+				}
+			}
+			filtered := state.frames[f].Register[23]&(1<<i) != 0
+
+			if cur != prevNote || c != prevColor || filtered != prevFiltered {
+				flush(f)
+				start = f
+				prevNote = cur
+				prevColor = c
+				prevFiltered = filtered
+			}
+
+			// Waveform changes
+			if f == 0 || v.Wave != state.frames[f-1].Channel[i].Wave {
+				state.rects = append(state.rects, prRect{f, waveTop + i*PR_WAVE_LANE, 1, PR_WAVE_LANE - 1, prVoice[i], ""})
+			}
+		}
+		flush(width)
+	}
+
+	// Master volume as a bar graph, filter cutoff as a line
+	for f := range state.frames {
+		regs := &state.frames[f].Register
+		vol := int(regs[24]&0xF) * 2
+		cutoff := (int(regs[21]&7) | int(regs[22])<<3) * (PR_LEVEL_LANE - 1) / 0x7FF
+		state.rects = append(state.rects, prRect{f, height - vol, 1, vol, prVolume, ""})
+		state.rects = append(state.rects, prRect{f, height - 1 - cutoff, 1, 1, prCutoff, ""})
+	}
+
+	state.labels = append(state.labels, prLabel{2, waveTop + 3*PR_WAVE_LANE, "Wave"})
+	state.labels = append(state.labels, prLabel{2, levelTop + 8, "Vol/Cutoff"})
+	return height
+}
+
+// noteY returns the y coordinate of the bottom line of a note
+func noteY(note int) int {
+	return (PR_NOTES - note) * PR_NOTE_HEIGHT
+}
+
+func (state *PianoRollImage) renderPNG(width int, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{prBackground}, image.Point{}, draw.Src)
+	for _, r := range state.rects {
+		draw.Draw(img, image.Rect(r.X, r.Y, r.X+r.W, r.Y+r.H), &image.Uniform{r.Color}, image.Point{}, draw.Src)
+	}
+	return img
+}
+
+func (state *PianoRollImage) renderSVG(w io.Writer, width int, height int) {
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" shape-rendering=\"crispEdges\">\n", width, height)
+	fmt.Fprintf(w, "<title>%s</title>\n", html.EscapeString(psid.CString(state.Header.Name[:])))
+	fmt.Fprintf(w, "<rect width=\"%d\" height=\"%d\" fill=\"%s\"/>\n", width, height, svgColor(prBackground))
+	for _, r := range state.rects {
+		fmt.Fprintf(w, "<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" fill=\"%s\"", r.X, r.Y, r.W, r.H, svgColor(r.Color))
+		if r.Title != "" {
+			fmt.Fprintf(w, "><title>%s</title></rect>\n", html.EscapeString(r.Title))
+		} else {
+			fmt.Fprintf(w, "/>\n")
+		}
+	}
+	for _, l := range state.labels {
+		fmt.Fprintf(w, "<text x=\"%d\" y=\"%d\" font-family=\"monospace\" font-size=\"8\" fill=\"#C0C0C0\">%s</text>\n", l.X, l.Y, html.EscapeString(l.Text))
+	}
+	fmt.Fprintf(w, "</svg>\n")
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
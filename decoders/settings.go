@@ -0,0 +1,20 @@
+package decoders
+
+// SidOutputSettings holds the options of the output decoders
+type SidOutputSettings struct {
+	Basefreq      int
+	Basenote      int
+	Firstframe    int
+	Lowres        int
+	Oldnotefactor int
+	Pattspacing   int
+	Profiling     int
+	Subtune       int
+	Spacing       int
+	Timeseconds   int
+}
+
+func NewSidOutputSettings() *SidOutputSettings {
+	opt := &SidOutputSettings{}
+	return opt
+}
@@ -0,0 +1,204 @@
+package decoders
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"siddump/sid"
+)
+
+// Height of one register row in the heatmap image, in pixels
+const HEATMAP_ROW_HEIGHT = 8
+
+// Number of most used values listed per register
+const STATS_TOP_VALUES = 6
+
+var heatChanged = color.RGBA{0xFF, 0xD0, 0x40, 0xFF}
+var heatWritten = color.RGBA{0x80, 0x40, 0x20, 0xFF}
+var heatIdle = color.RGBA{0x10, 0x10, 0x10, 0xFF}
+
+// struct to implement decoder that gathers statistics on register
+// changes and writes over the whole dump
+type RegisterStatistics struct {
+	Options  *SidOutputSettings
+	SidState *sid.Sid
+	Out      io.Writer
+	Log      io.Writer
+
+	// Register change heatmap PNG, not written when nil
+	Heatmap io.Writer
+
+	prevSidState  *sid.Sid
+	frames        int
+	time          time.Duration
+	changes       [25]int
+	writes        [25]int
+	histogram     [25][256]int
+	framesChanged []int
+	framesWritten []int
+	heat          [][25]uint8
+}
+
+// SetTime adds the duration of the next frame to the time covered
+func (state *RegisterStatistics) SetTime(t time.Duration, duration time.Duration) {
+	state.time += duration
+}
+
+func (state *RegisterStatistics) PreSteps() error {
+	state.prevSidState = sid.NewSID()
+	return nil
+}
+
+func (state *RegisterStatistics) ProcessFrame(frame int, cycles uint64) error {
+	cur := state.SidState
+	prev := state.prevSidState
+
+	var column [25]uint8
+	changed, written := 0, 0
+	for r := 0; r < 25; r++ {
+		state.histogram[r][cur.Register[r]]++
+		state.writes[r] += int(cur.Writes[r])
+		written += int(cur.Writes[r])
+		if cur.Writes[r] != 0 {
+			column[r] = 1
+		}
+
+		if state.frames > 0 && cur.Register[r] != prev.Register[r] {
+			state.changes[r]++
+			changed++
+			column[r] = 2
+		}
+	}
+
+	state.framesChanged = countFrame(state.framesChanged, changed)
+	state.framesWritten = countFrame(state.framesWritten, written)
+	if state.Heatmap != nil {
+		state.heat = append(state.heat, column)
+	}
+
+	prev.CopyFrom(cur)
+	state.frames++
+	return nil
+}
+
+// countFrame adds one frame to a histogram indexed by count
+func countFrame(hist []int, count int) []int {
+	for len(hist) <= count {
+		hist = append(hist, 0)
+	}
+	hist[count]++
+	return hist
+}
+
+func (state *RegisterStatistics) PostSteps() error {
+	fmt.Fprintf(state.Out, "Register statistics over %d frames\n\n", state.frames)
+	fmt.Fprintf(state.Out, "| Reg | Name    | Writes | Changes | Chg/s | Values | Most used values                                     |\n")
+	fmt.Fprintf(state.Out, "+-----+---------+--------+---------+-------+--------+------------------------------------------------------+\n")
+
+	// Without the frame times from the player, frames are PAL vertical
+	// blanks
+	seconds := state.time.Seconds()
+	if state.time == 0 {
+		seconds = float64(state.frames) / 50
+	}
+	if seconds == 0 {
+		seconds = 1
+	}
+
+	for r := 0; r < 25; r++ {
+		values := 0
+		for v := 0; v < 256; v++ {
+			if state.histogram[r][v] != 0 {
+				values++
+			}
+		}
+		fmt.Fprintf(state.Out, "| $%02X | %-7s | %6d | %7d | %5.1f | %6d | %-52s |\n", r, registerNames[r], state.writes[r], state.changes[r],
+			float64(state.changes[r])/seconds, values, topValues(state.histogram[r][:], state.frames))
+	}
+
+	fmt.Fprintf(state.Out, "\n| Regs/frame | Frames changed | Frames written |\n")
+	fmt.Fprintf(state.Out, "+------------+----------------+----------------+\n")
+	rows := len(state.framesChanged)
+	if len(state.framesWritten) > rows {
+		rows = len(state.framesWritten)
+	}
+	for n := 0; n < rows; n++ {
+		changed, written := 0, 0
+		if n < len(state.framesChanged) {
+			changed = state.framesChanged[n]
+		}
+		if n < len(state.framesWritten) {
+			written = state.framesWritten[n]
+		}
+		if changed == 0 && written == 0 {
+			continue
+		}
+		fmt.Fprintf(state.Out, "| %10d | %14d | %14d |\n", n, changed, written)
+	}
+
+	if state.Heatmap != nil {
+		return state.writeHeatmap()
+	}
+	return nil
+}
+
+// topValues lists the most used values of a register with the share of
+// frames they were set
+func topValues(hist []int, frames int) string {
+	values := make([]int, 0, 256)
+	for v := range hist {
+		if hist[v] != 0 {
+			values = append(values, v)
+		}
+	}
+	sort.SliceStable(values, func(a, b int) bool {
+		return hist[values[a]] > hist[values[b]]
+	})
+
+	var s []string
+	for i, v := range values {
+		if i == STATS_TOP_VALUES {
+			break
+		}
+		s = append(s, fmt.Sprintf("%02X:%3d%%", v, hist[v]*100/frames))
+	}
+	return strings.Join(s, " ")
+}
+
+// writeHeatmap draws one column per frame and one row per register:
+// bright where the register changed, dim where it was only rewritten
+func (state *RegisterStatistics) writeHeatmap() error {
+	if len(state.heat) == 0 {
+		// An image without columns can't be encoded
+		logf(state.Log, "Heatmap: no frames, not written\n")
+		return nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, len(state.heat), 25*HEATMAP_ROW_HEIGHT))
+	for x, column := range state.heat {
+		for r := 0; r < 25; r++ {
+			c := heatIdle
+			switch column[r] {
+			case 1:
+				c = heatWritten
+			case 2:
+				c = heatChanged
+			}
+			for y := 0; y < HEATMAP_ROW_HEIGHT-1; y++ {
+				img.SetRGBA(x, r*HEATMAP_ROW_HEIGHT+y, c)
+			}
+		}
+	}
+
+	if err := png.Encode(state.Heatmap, img); err != nil {
+		return err
+	}
+
+	logf(state.Log, "Heatmap: %dx%d\n", img.Bounds().Dx(), img.Bounds().Dy())
+	return nil
+}
@@ -0,0 +1,24 @@
+package decoders
+
+import (
+	"fmt"
+	"io"
+)
+
+// logf writes a diagnostic message, if there is a log to write it to
+func logf(log io.Writer, format string, a ...any) {
+	if log != nil {
+		fmt.Fprintf(log, format, a...)
+	}
+}
+
+func absInt(x int) int {
+	return absDiffInt(x, 0)
+}
+
+func absDiffInt(x, y int) int {
+	if x < y {
+		return y - x
+	}
+	return x - y
+}
@@ -0,0 +1,325 @@
+package decoders
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"siddump/psid"
+	"siddump/sid"
+)
+
+// FastTracker 2 limits
+const (
+	XM_MAX_INSTR    = 128
+	XM_MAX_PATT     = 256
+	XM_MAX_SONGLEN  = 256
+	XM_MAX_PATTROWS = 256
+	XM_CHANNELS     = 4
+)
+
+const (
+	XM_KEYOFF     = 97
+	XM_FX_PORTA   = 0x03
+	XM_FX_TEMPO   = 0x0F
+	XM_BPM        = 125 // 125 BPM equals 50 ticks per second
+	XM_WAVE_LEN   = 128 // One waveform cycle, played 2 octaves up
+	XM_NOISE_LEN  = 2048
+	XM_RELNOTE    = 24
+	XM_INSTR_SIZE = 263
+)
+
+// xmInstrument is a sampled SID voice setting: waveform, pulse width and
+// ADSR
+type xmInstrument struct {
+	Wave  uint8
+	Pulse uint16
+	ADSR  uint16
+}
+
+// struct to implement decoder that converts the dump into a
+// FastTracker 2 XM module
+type XMExport struct {
+	Options  *SidOutputSettings
+	SidState *sid.Sid
+	Out      io.Writer
+	Log      io.Writer
+	Header   *psid.PSIDHeader
+
+	frames  []sid.Sid
+	tracker *NoteTracker
+
+	instruments []xmInstrument
+	instrKeys   map[xmInstrument]int
+	patterns    [][]uint8
+	pattKeys    map[string]int
+	orders      []uint8
+	pattRows    []int
+}
+
+func (state *XMExport) PreSteps() error {
+	state.tracker = NewNoteTracker(state.Options.Oldnotefactor)
+	state.instrKeys = make(map[xmInstrument]int)
+	state.pattKeys = make(map[string]int)
+	return nil
+}
+
+func (state *XMExport) ProcessFrame(frame int, cycles uint64) error {
+	state.frames = append(state.frames, *state.SidState)
+	state.tracker.Track(state.SidState)
+	return nil
+}
+
+func (state *XMExport) PostSteps() error {
+	state.tracker.Finish()
+
+	speed := state.Options.Spacing
+	if speed <= 0 {
+		speed = 6
+	}
+	pattRows := state.Options.Pattspacing
+	if pattRows <= 0 || pattRows > XM_MAX_PATTROWS {
+		pattRows = 64
+	}
+
+	numRows := (state.tracker.Frames() + speed - 1) / speed
+	rows := make([][XM_CHANNELS][5]uint8, numRows)
+	for i := 0; i < 3; i++ {
+		state.buildRows(rows, i, speed)
+	}
+
+	for r := 0; r < len(rows); r += pattRows {
+		end := r + pattRows
+		if end > len(rows) {
+			end = len(rows)
+		}
+		patt := state.addPattern(rows[r:end])
+		if patt < 0 || len(state.orders) >= XM_MAX_SONGLEN {
+			logf(state.Log, "Warning: XM limits reached, song truncated\n")
+			break
+		}
+		state.orders = append(state.orders, uint8(patt))
+	}
+
+	w := bufio.NewWriter(state.Out)
+	state.write(w, speed)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	logf(state.Log, "XM module: %d instruments, %d patterns, speed %d\n", len(state.instruments), len(state.patterns), speed)
+	return nil
+}
+
+// buildRows quantizes the notes of one voice into rows of speed frames.
+func (state *XMExport) buildRows(rows [][XM_CHANNELS][5]uint8, voice int, speed int) {
+	notes := state.tracker.Notes(voice)
+	for n, note := range notes {
+		r := note.Start / speed
+		cell := &rows[r][voice]
+		if cell[0] != 0 && cell[0] != XM_KEYOFF {
+			// Only the first note within a row is kept
+			continue
+		}
+
+		cell[0] = uint8(note.Note + 1)
+		if note.Legato {
+			cell[1] = 0
+			cell[3] = XM_FX_PORTA
+			cell[4] = 0xFF
+		} else {
+			cell[1] = uint8(state.addInstrument(&note))
+		}
+
+		// Key off, unless the next note follows directly
+		off := (note.End + speed - 1) / speed
+		if off < len(rows) && (n+1 == len(notes) || notes[n+1].Start > note.End) && rows[off][voice][0] == 0 {
+			rows[off][voice][0] = XM_KEYOFF
+		}
+	}
+
+	if voice == 0 && len(rows) > 0 && rows[0][0][3] == 0 {
+		rows[0][0][3] = XM_FX_TEMPO
+		rows[0][0][4] = uint8(speed)
+	}
+}
+
+// addInstrument returns the instrument matching the voice settings at
+// the start of a note, creating it when needed.
+func (state *XMExport) addInstrument(note *NoteEvent) int {
+	v := &state.frames[note.Start].Channel[note.Voice]
+	instr := xmInstrument{Wave: v.Wave & 0xF0, ADSR: v.ADSR}
+	if instr.Wave&0x40 != 0 {
+		// Pulse widths are sampled in 1/16 steps
+		instr.Pulse = v.Pulse & 0xF00
+	}
+
+	if num, ok := state.instrKeys[instr]; ok {
+		return num
+	}
+	if len(state.instruments) >= XM_MAX_INSTR {
+		return 1
+	}
+	state.instruments = append(state.instruments, instr)
+	state.instrKeys[instr] = len(state.instruments)
+	return len(state.instruments)
+}
+
+// addPattern stores a block of rows as a pattern and returns its number,
+// or -1 when there are no free patterns.
+func (state *XMExport) addPattern(rows [][XM_CHANNELS][5]uint8) int {
+	var data []uint8
+	for _, row := range rows {
+		for _, cell := range row {
+			if cell == [5]uint8{} {
+				// Packed empty cell
+				data = append(data, 0x80)
+			} else {
+				data = append(data, cell[:]...)
+			}
+		}
+	}
+
+	key := string(data)
+	if patt, ok := state.pattKeys[key]; ok {
+		return patt
+	}
+	if len(state.patterns) >= XM_MAX_PATT {
+		return -1
+	}
+	state.patterns = append(state.patterns, data)
+	state.pattRows = append(state.pattRows, len(rows))
+	state.pattKeys[key] = len(state.patterns) - 1
+	return len(state.patterns) - 1
+}
+
+func (state *XMExport) write(w *bufio.Writer, speed int) {
+	var name [20]byte
+	var tracker [20]byte
+	var orders [256]uint8
+
+	copy(name[:], state.Header.Name[:])
+	copy(tracker[:], "siddump-go")
+	copy(orders[:], state.orders)
+
+	w.WriteString("Extended Module: ")
+	w.Write(name[:])
+	w.WriteByte(0x1A)
+	w.Write(tracker[:])
+	binary.Write(w, binary.LittleEndian, []uint16{0x0104})
+	binary.Write(w, binary.LittleEndian, uint32(276))
+	binary.Write(w, binary.LittleEndian, []uint16{
+		uint16(len(state.orders)), 0, XM_CHANNELS,
+		uint16(len(state.patterns)), uint16(len(state.instruments)),
+		1, uint16(speed), XM_BPM,
+	})
+	w.Write(orders[:])
+
+	for p, patt := range state.patterns {
+		binary.Write(w, binary.LittleEndian, uint32(9))
+		w.WriteByte(0)
+		binary.Write(w, binary.LittleEndian, []uint16{uint16(state.pattRows[p]), uint16(len(patt))})
+		w.Write(patt)
+	}
+
+	for _, instr := range state.instruments {
+		writeXMInstrument(w, &instr)
+	}
+}
+
+func writeXMInstrument(w *bufio.Writer, instr *xmInstrument) {
+	var hdr [XM_INSTR_SIZE]byte
+	var env [12][2]uint16
+
+	binary.LittleEndian.PutUint32(hdr[0:], XM_INSTR_SIZE)
+	copy(hdr[4:26], fmt.Sprintf("WF %02X PW %03X ADSR %04X", instr.Wave, instr.Pulse, instr.ADSR))
+	binary.LittleEndian.PutUint16(hdr[27:], 1)
+	binary.LittleEndian.PutUint32(hdr[29:], 40)
+
+	// Volume envelope in frames (ticks): attack, decay to sustain level,
+	// release after key off
+	attack := sid.AttackMs[instr.ADSR>>12] / 20
+	decay := sid.DecayMs[(instr.ADSR>>8)&0xF] / 20
+	sustain := uint16((instr.ADSR>>4)&0xF) * 64 / 15
+	release := sid.DecayMs[instr.ADSR&0xF] / 20
+	env[0] = [2]uint16{0, 0}
+	env[1] = [2]uint16{uint16(attack + 1), 64}
+	env[2] = [2]uint16{uint16(attack + decay + 2), sustain}
+	env[3] = [2]uint16{uint16(attack + decay + release + 3), 0}
+	for p, point := range env {
+		binary.LittleEndian.PutUint16(hdr[129+p*4:], point[0])
+		binary.LittleEndian.PutUint16(hdr[131+p*4:], point[1])
+	}
+	hdr[225] = 4 // volume points
+	hdr[227] = 2 // sustain point
+	hdr[233] = 3 // envelope on, sustain
+
+	w.Write(hdr[:])
+
+	data := renderWaveform(instr.Wave, instr.Pulse)
+
+	var smp [40]byte
+	binary.LittleEndian.PutUint32(smp[0:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(smp[4:], 0)
+	binary.LittleEndian.PutUint32(smp[8:], uint32(len(data)))
+	smp[12] = 48  // volume
+	smp[14] = 1   // forward loop
+	smp[15] = 128 // panning
+	smp[16] = XM_RELNOTE
+	copy(smp[18:], "SID")
+	w.Write(smp[:])
+
+	// Sample data is delta encoded
+	var old int8
+	for _, s := range data {
+		w.WriteByte(uint8(s - old))
+		old = s
+	}
+}
+
+// renderWaveform renders one cycle of a SID waveform as 8-bit signed
+// samples. Combined waveforms are approximated by ANDing the outputs,
+// noise is rendered as a longer random loop.
+func renderWaveform(wave uint8, pulse uint16) []int8 {
+	if wave&0x80 != 0 {
+		rng := rand.New(rand.NewSource(int64(wave)))
+		data := make([]int8, XM_NOISE_LEN)
+		for i := range data {
+			if i%8 == 0 {
+				data[i] = int8(rng.Intn(256) - 128)
+			} else {
+				data[i] = data[i-1]
+			}
+		}
+		return data
+	}
+
+	data := make([]int8, XM_WAVE_LEN)
+	for i := range data {
+		// 8-bit accumulator position within the cycle
+		pos := uint8(i * 256 / XM_WAVE_LEN)
+		out := uint8(0xFF)
+		if wave&0x10 != 0 {
+			tri := pos << 1
+			if pos&0x80 != 0 {
+				tri = ^tri
+			}
+			out &= tri
+		}
+		if wave&0x20 != 0 {
+			out &= pos
+		}
+		if wave&0x40 != 0 {
+			if uint16(pos)<<4 >= pulse {
+				out = 0
+			}
+		}
+		if wave&0x70 == 0 {
+			out = 0x80
+		}
+		data[i] = int8(out - 0x80)
+	}
+	return data
+}
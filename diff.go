@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"siddump/compare"
+	"siddump/player"
+)
+
+// Frames shown before the first difference in the side by side view
+const DIFF_CONTEXT = 2
+
+// Compare plays two tunes, or reads two register dumps, and compares their
+// SID registers frame by frame
+func (d *Dumper) Compare(nameA string, nameB string, out io.Writer) error {
+	a, err := d.registerFrames(nameA)
+	if err != nil {
+		return err
+	}
+	b, err := d.registerFrames(nameB)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "A: %s, %d frames\n", nameA, len(a))
+	fmt.Fprintf(out, "B: %s, %d frames\n", nameB, len(b))
+
+	res := compare.Compare(a, b, d.Options.Tolerance)
+	res.PrintResult(out)
+	if res.First < 0 {
+		return nil
+	}
+	fmt.Fprintln(out)
+	res.WriteSideBySide(out, a, b, res.First-DIFF_CONTEXT, d.Options.DiffLines)
+	return errors.New("SID registers differ")
+}
+
+// registerFrames reads a register dump ending in .dmp, or plays a tune and
+// records the SID registers of every frame
+func (d *Dumper) registerFrames(name string) (compare.Frames, error) {
+	if strings.EqualFold(filepath.Ext(name), ".dmp") {
+		file, err := player.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		return compare.ReadDump(bytes.NewReader(file))
+	}
+
+	tune, err := d.open(name)
+	if err != nil {
+		return nil, err
+	}
+	playback, err := tune.Play(d.Options.Subtune, d.Options.Seconds*50)
+	if err != nil {
+		return nil, err
+	}
+	var frames compare.Frames
+	for f := range playback.Frames(context.Background()) {
+		frames = append(frames, f.Sid.Register)
+	}
+	return frames, playback.Err()
+}
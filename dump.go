@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"siddump/debugger"
+	"siddump/decoders"
+	"siddump/player"
+	"siddump/psid"
+	"siddump/sid"
+)
+
+var ErrTimeout = errors.New("timed out")
+
+// Dumper plays tunes and feeds every frame to the selected decoder. The
+// databases are loaded once so the dumper can be shared by many tunes.
+type Dumper struct {
+	Options     *Settings
+	SongLengths *psid.SongLengths
+	STIL        *psid.STIL
+	PlayerIds   *psid.PlayerIds
+	TraceFrames *debugger.FrameSet
+
+	// Maximum wall-clock time for one tune, 0 for no limit
+	Timeout time.Duration
+}
+
+// NewDumper loads the databases given in the options
+func NewDumper(opt *Settings) (*Dumper, error) {
+	d := &Dumper{Options: opt, Timeout: time.Duration(opt.Timeout) * time.Second}
+	var err error
+
+	if d.TraceFrames, err = debugger.ParseFrameSet(opt.TraceFrames); err != nil {
+		return nil, err
+	}
+
+	if opt.Songlengths != "" {
+		if d.SongLengths, err = psid.LoadSongLengths(opt.Songlengths); err != nil {
+			return nil, err
+		}
+	}
+	if opt.Stil != "" {
+		if d.STIL, err = psid.LoadSTIL(opt.Stil); err != nil {
+			return nil, err
+		}
+	}
+	if opt.Sidid != "" {
+		if d.PlayerIds, err = psid.LoadPlayerIds(opt.Sidid); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// open loads a tune from a PSID file, from a Compute's Sidplayer file
+// ending in .mus or .str, or from a C64 program file when it ends in .prg
+// or a load address is given. The files may be gzip compressed. -init and
+// -play override the addresses of PSID and Sidplayer files.
+func (d *Dumper) open(sidName string) (*player.Tune, error) {
+	opt := d.Options
+	name := strings.ToLower(sidName)
+	ext := filepath.Ext(strings.TrimSuffix(name, ".gz"))
+	var tune *player.Tune
+	var err error
+	switch {
+	case opt.Load != "" || ext == ".prg":
+		return d.openProgram(sidName)
+	case ext == ".mus" || ext == ".str":
+		tune, err = player.OpenMUS(sidName)
+	default:
+		tune, err = player.Open(sidName)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tune.Header.IsMUS() && opt.MusPlayer != "" {
+		if err := tune.LoadMUSPlayer(opt.MusPlayer); err != nil {
+			return nil, err
+		}
+	}
+	if opt.Init != "" {
+		if tune.Header.InitAddress, err = parseAddress(opt.Init); err != nil {
+			return nil, err
+		}
+	}
+	if opt.Play != "" {
+		if tune.Header.PlayAddress, err = parseAddress(opt.Play); err != nil {
+			return nil, err
+		}
+	}
+	return tune, nil
+}
+
+// openProgram loads a tune from a C64 program file or raw binary with the
+// addresses given in the options
+func (d *Dumper) openProgram(fileName string) (*player.Tune, error) {
+	var addr [3]uint16
+	for i, s := range []string{d.Options.Load, d.Options.Init, d.Options.Play} {
+		var err error
+		if addr[i], err = parseAddress(s); err != nil {
+			return nil, err
+		}
+	}
+	return player.OpenProgram(fileName, addr[0], addr[1], addr[2])
+}
+
+// Dump plays a subtune of a tune. Text output of the decoders is written
+// to out, files are named after outBase and diagnostics go to log. It
+// stops early when ctx is cancelled.
+func (d *Dumper) Dump(ctx context.Context, sidName string, subtune int, out io.Writer, log io.Writer, outBase string) error {
+	// Decoders may change their options, every tune gets its own copy
+	opt := *d.Options
+	opt.Subtune = subtune
+
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	tune, err := d.open(sidName)
+	if err != nil {
+		return err
+	}
+	tune.Log = log
+	header := tune.Header
+
+	header.PrintPSIDVitals(log)
+
+	// Show STIL information of the tune
+	var stil *psid.STILEntry
+	if d.STIL != nil {
+		stil = d.STIL.Lookup(sidName)
+		if stil != nil {
+			stil.PrintSTIL(log, opt.Subtune+1)
+		} else {
+			fmt.Fprintln(log, "STIL: no entry")
+		}
+	}
+
+	// Identify the music player
+	if d.PlayerIds != nil {
+		psid.PrintPlayers(log, d.PlayerIds.Identify(tune.Data))
+	}
+
+	// Playback time, from the song length database when available. The
+	// length is played up to the time the frames add up to, as the play
+	// routine is not always called 50 times a second.
+	playFrames := opt.Seconds * 50
+	var length time.Duration
+	if d.SongLengths != nil {
+		if seconds, ok := tune.SongLength(d.SongLengths, opt.Subtune); ok {
+			length = time.Duration(seconds * float64(time.Second))
+			playFrames = math.MaxInt - opt.Firstframe
+			fmt.Fprintf(log, "Song length from database: %s\n", player.FormatTime(length))
+		} else {
+			fmt.Fprintln(log, "Warning: tune not found in song length database, using -t")
+		}
+	}
+
+	files := &outputFiles{}
+	defer files.Close()
+
+	// Trace the emulation from the init routine on
+	var tracer *debugger.Tracer
+	if opt.Trace != "" {
+		w, err := files.Create(opt.Trace)
+		if err != nil {
+			return err
+		}
+		tracer = debugger.NewTracer(w, d.TraceFrames)
+		tune.Setup = append(tune.Setup, tracer.Attach)
+		defer tracer.Flush()
+	}
+	var memMap *debugger.MemoryMap
+	if opt.MemMap != "" {
+		memMap = debugger.NewMemoryMap()
+		tune.Setup = append(tune.Setup, memMap.Attach)
+	}
+
+	// Print info and run initroutine
+	fmt.Fprintf(log, "Load address: $%04X Init address: $%04X Play address: $%04X\n", header.LoadAddress, header.InitAddress, header.PlayAddress)
+	fmt.Fprintf(log, "Calling initroutine with subtune %d\n", opt.Subtune)
+	playback, err := tune.Play(opt.Subtune, opt.Firstframe+playFrames)
+	if err != nil {
+		return err
+	}
+	playback.Length = length
+
+	// Create requested output struct types. Every decoder gets its own
+	// options and SID state, as some of them modify these.
+	currentSid := sid.NewSID()
+	output := &decoders.ActiveDecoder{}
+	var sids []*sid.Sid
+	for _, spec := range opt.Outputs {
+		path := outputPath(spec, outBase)
+		w := out
+		if path != "" {
+			if w, err = files.Create(path); err != nil {
+				return err
+			}
+		}
+
+		decoderOpt := opt.SidOutputSettings
+		s := sid.NewSID()
+		dec := decoders.NewDecoder(spec.Mode, &decoderOpt, s, header, stil, w, log)
+		switch dec := dec.(type) {
+		case *decoders.PianoRollImage:
+			if path != "" {
+				dec.SVG, err = files.Create(strings.TrimSuffix(path, filepath.Ext(path)) + ".svg")
+			}
+		case *decoders.RegisterStatistics:
+			if opt.Heatmap != "" {
+				dec.Heatmap, err = files.Create(opt.Heatmap)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		output.AddOutput(dec)
+		sids = append(sids, s)
+	}
+
+	if length != 0 {
+		fmt.Fprintf(log, "Calling playroutine for %s, starting from frame %d\n", player.FormatTime(length), opt.Firstframe)
+	} else {
+		fmt.Fprintf(log, "Calling playroutine for %d frames, starting from frame %d\n", playFrames, opt.Firstframe)
+	}
+
+	if err := output.PreProcess(); err != nil {
+		return err
+	}
+
+	var loop *player.LoopDetector
+	if opt.Loop != 0 {
+		loop = player.NewLoopDetector()
+	}
+
+	for f := range playback.Frames(ctx) {
+		// The decoders keep the notes of the voices between frames
+		currentSid.CopyRegisters(&f.Sid)
+		for _, s := range sids {
+			s.CopyRegisters(&f.Sid)
+		}
+
+		// Frame display
+		if f.Number >= opt.Firstframe {
+			output.SetTime(f.Time, f.Duration)
+			if err := output.ProcessFrame(f.Number, f.Cycles); err != nil {
+				return err
+			}
+		}
+
+		// Stop after one pass of the tune
+		if loop != nil && loop.Check(playback.CPU, currentSid, &f) {
+			break
+		}
+	}
+	if err := playback.Err(); errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	} else if err != nil {
+		return err
+	}
+
+	if tracer != nil {
+		if err := tracer.Flush(); err != nil {
+			return err
+		}
+	}
+	if memMap != nil {
+		if err := writeMemoryMap(memMap, opt.MemMap, files, log); err != nil {
+			return err
+		}
+	}
+	if err := output.PostProcess(); err != nil {
+		return err
+	}
+	if err := files.Close(); err != nil {
+		return err
+	}
+	for _, path := range files.paths {
+		fmt.Fprintf(log, "Wrote %s\n", path)
+	}
+
+	if loop != nil {
+		loop.PrintResult(log)
+	}
+	return nil
+}
+
+// writeMemoryMap writes the address ranges to path and the image next to
+// it, and prints the summary to log
+func writeMemoryMap(memMap *debugger.MemoryMap, path string, files *outputFiles, log io.Writer) error {
+	w, err := files.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := memMap.WriteRanges(w); err != nil {
+		return err
+	}
+	if w, err = files.Create(strings.TrimSuffix(path, filepath.Ext(path)) + ".png"); err != nil {
+		return err
+	}
+	if err := memMap.WriteImage(w); err != nil {
+		return err
+	}
+	memMap.PrintSummary(log)
+	return nil
+}
+
+// outputPath returns the file the output of a decoder is written to, or
+// an empty string for the text output
+func outputPath(spec OutputSpec, outBase string) string {
+	switch {
+	case spec.Path == "-":
+		return ""
+	case spec.Path != "":
+		return spec.Path
+	case decoders.Extension(spec.Mode) != "":
+		return outBase + decoders.Extension(spec.Mode)
+	}
+	return ""
+}
+
+// outputFiles are the files written by the decoders of one run
+type outputFiles struct {
+	paths   []string
+	files   []*os.File
+	writers []*bufio.Writer
+}
+
+func (o *outputFiles) Create(path string) (io.Writer, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	o.paths = append(o.paths, path)
+	o.files = append(o.files, file)
+	o.writers = append(o.writers, w)
+	return w, nil
+}
+
+// Close flushes and closes all files and returns the first error. Files
+// nothing was written to, like the images of a dump without frames, are
+// removed.
+func (o *outputFiles) Close() error {
+	var first error
+	var paths []string
+	for i, file := range o.files {
+		if err := o.writers[i].Flush(); err != nil && first == nil {
+			first = err
+		}
+		info, statErr := file.Stat()
+		if err := file.Close(); err != nil && first == nil {
+			first = err
+		}
+		if statErr == nil && info.Mode().IsRegular() && info.Size() == 0 {
+			os.Remove(o.paths[i])
+			continue
+		}
+		paths = append(paths, o.paths[i])
+	}
+	if o.files != nil {
+		o.paths = paths
+	}
+	o.files = nil
+	o.writers = nil
+	return first
+}
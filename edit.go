@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"siddump/player"
+	"siddump/psid"
+)
+
+var clockNames = []string{"unknown", "pal", "ntsc", "any"}
+var modelNames = []string{"unknown", "6581", "8580", "any"}
+
+// Edit changes the header fields given in the edit options and writes the
+// tune to outName, or back to the tune when outName is empty
+func (d *Dumper) Edit(sidName string, outName string, log io.Writer) error {
+	tune, err := player.Open(sidName)
+	if err != nil {
+		return err
+	}
+	header := tune.Header
+	if err := applyEdit(header, &d.Options.Edit); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := header.WritePSID(&buf, tune.Data); err != nil {
+		return err
+	}
+	if outName == "" {
+		outName = sidName
+	}
+	if err := os.WriteFile(outName, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	// Show the header as it was written
+	edited, err := player.Open(outName)
+	if err != nil {
+		return err
+	}
+	edited.Header.PrintPSIDVitals(log)
+	fmt.Fprintf(log, "Clock: %s\n", clockNames[edited.Header.Clock()])
+	fmt.Fprintf(log, "SID model: %s\n", modelNames[edited.Header.SIDModel()])
+	fmt.Fprintf(log, "Wrote %s\n", outName)
+	return nil
+}
+
+func applyEdit(header *psid.PSIDHeader, edit *EditSettings) error {
+	for _, text := range []struct {
+		value string
+		field *[32]byte
+	}{{edit.Name, &header.Name}, {edit.Author, &header.Author}, {edit.Released, &header.Released}} {
+		if text.value == "" {
+			continue
+		}
+		if err := psid.SetText(text.field, text.value); err != nil {
+			return fmt.Errorf("%w: %s", err, text.value)
+		}
+	}
+
+	if edit.Songs != "" {
+		songs, err := strconv.Atoi(edit.Songs)
+		if err != nil || songs < 1 || songs > 256 {
+			return fmt.Errorf("bad number of songs %s", edit.Songs)
+		}
+		header.Songs = uint16(songs)
+		if header.StartSong > header.Songs && edit.StartSong == "" {
+			header.StartSong = 1
+		}
+	}
+	if edit.StartSong != "" {
+		song, err := strconv.Atoi(edit.StartSong)
+		if err != nil || song < 1 {
+			return fmt.Errorf("bad start song %s", edit.StartSong)
+		}
+		header.StartSong = uint16(song)
+	}
+	if edit.Speed != "" {
+		speed, err := strconv.ParseUint(edit.Speed, 16, 32)
+		if err != nil {
+			return fmt.Errorf("bad speed %s", edit.Speed)
+		}
+		header.Speed = uint32(speed)
+	}
+	if edit.Clock != "" {
+		clock := nameIndex(clockNames, edit.Clock)
+		if clock < 0 {
+			return fmt.Errorf("bad clock %s", edit.Clock)
+		}
+		header.SetClock(clock)
+	}
+	if edit.Model != "" {
+		model := nameIndex(modelNames, edit.Model)
+		if model < 0 {
+			return fmt.Errorf("bad SID model %s", edit.Model)
+		}
+		header.SetSIDModel(model)
+	}
+	return nil
+}
+
+func nameIndex(names []string, name string) int {
+	for i, n := range names {
+		if strings.EqualFold(n, name) {
+			return i
+		}
+	}
+	return -1
+}
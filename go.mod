@@ -1,5 +1,5 @@
 module siddump
 
-go 1.22.5
+go 1.23
 
-require github.com/beevik/go6502 v0.3.0 // indirect
+require github.com/beevik/go6502 v0.3.0
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"siddump/decoders"
+	"siddump/psid"
+
+	"github.com/beevik/go6502/asm"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// Output modes checked against the golden files
+var goldenModes = []int{0, 1, 4, 5, 6, 7, 8, 9}
+
+// Synthetic tunes, assembled at $1000 with jumps to the init and play
+// routines at the start. Each one exercises some of what the decoders
+// track.
+var goldenTunes = []struct {
+	name  string
+	speed uint32
+	src   string
+}{
+	// Notes with the gate toggled on three voices, the third one a noise
+	// drum
+	{"gate", 0, `
+	.ORG $1000
+	JMP INIT
+	JMP PLAY
+INIT	LDA #$0F
+	STA $D418
+	LDA #$09
+	STA $D405
+	LDA #$A0
+	STA $D406
+	LDA #$22
+	STA $D40C
+	LDA #$40
+	STA $D40D
+	LDA #$01
+	STA $D413
+	LDA #$00
+	STA $D414
+	LDA #$08
+	STA $D403
+	LDA #$00
+	STA CNT
+	STA IDX
+	RTS
+PLAY	INC CNT
+	LDA CNT
+	AND #$07
+	BNE NONEW
+	LDX IDX
+	LDA NOTELO,X
+	STA $D400
+	STA $D407
+	LDA NOTEHI,X
+	STA $D401
+	LSR
+	STA $D408
+	LDA #$41
+	STA $D404
+	LDA #$11
+	STA $D40B
+	INX
+	TXA
+	AND #$07
+	STA IDX
+	LDA CNT
+	AND #$0F
+	BNE NODRUM
+	LDA #$20
+	STA $D40F
+	LDA #$81
+	STA $D412
+NODRUM	RTS
+NONEW	CMP #$04
+	BNE NOREL2
+	LDA #$10
+	STA $D40B
+NOREL2	CMP #$06
+	BNE NOREL1
+	LDA #$40
+	STA $D404
+NOREL1	CMP #$02
+	BNE DONE
+	LDA #$80
+	STA $D412
+DONE	RTS
+CNT	.DB 0
+IDX	.DB 0
+NOTELO	.DB $68,$C5,$F7,$4F,$D1,$82,$68,$F7
+NOTEHI	.DB $08,$09,$0A,$0C,$0D,$0F,$11,$0A
+`},
+	// A sawtooth sliding up then down, and a triangle with vibrato
+	{"slide", 0, `
+	.ORG $1000
+	JMP INIT
+	JMP PLAY
+INIT	LDA #$0F
+	STA $D418
+	LDA #$00
+	STA $D405
+	STA $D40C
+	STA CNT
+	LDA #$F0
+	STA $D406
+	STA $D40D
+	LDA #$00
+	STA FLO
+	LDA #$08
+	STA FHI
+	LDA #$D1
+	STA VLO
+	STA $D407
+	LDA #$0D
+	STA $D408
+	LDA #$21
+	STA $D404
+	LDA #$11
+	STA $D40B
+	RTS
+PLAY	INC CNT
+	LDA CNT
+	CMP #$40
+	BCS DOWN
+	CLC
+	LDA FLO
+	ADC #$38
+	STA FLO
+	LDA FHI
+	ADC #$00
+	STA FHI
+	JMP SETF
+DOWN	SEC
+	LDA FLO
+	SBC #$70
+	STA FLO
+	LDA FHI
+	SBC #$00
+	STA FHI
+SETF	LDA FLO
+	STA $D400
+	LDA FHI
+	STA $D401
+	LDA CNT
+	AND #$04
+	BEQ VIBUP
+	LDA VLO
+	SEC
+	SBC #$18
+	JMP SETV
+VIBUP	LDA VLO
+	CLC
+	ADC #$18
+SETV	STA VLO
+	STA $D407
+	RTS
+CNT	.DB 0
+FLO	.DB 0
+FHI	.DB 0
+VLO	.DB 0
+`},
+	// A held triangle note with a slight vibrato every four frames, which
+	// stays within the note
+	{"vibrato", 0, `
+	.ORG $1000
+	JMP INIT
+	JMP PLAY
+INIT	LDA #$0F
+	STA $D418
+	LDA #$00
+	STA $D405
+	STA CNT
+	LDA #$F0
+	STA $D406
+	LDA #$68
+	STA $D400
+	LDA #$08
+	STA $D401
+	LDA #$11
+	STA $D404
+	RTS
+PLAY	INC CNT
+	LDA CNT
+	AND #$04
+	BEQ LOW
+	LDA #$6A
+	STA $D400
+	RTS
+LOW	LDA #$68
+	STA $D400
+	RTS
+CNT	.DB 0
+`},
+	// A pulse through the low pass filter with a rising cutoff, switched
+	// to band pass halfway
+	{"filter", 0, `
+	.ORG $1000
+	JMP INIT
+	JMP PLAY
+INIT	LDA #$1F
+	STA $D418
+	LDA #$F1
+	STA $D417
+	LDA #$00
+	STA CNT
+	STA $D415
+	STA $D416
+	STA $D405
+	LDA #$F0
+	STA $D406
+	LDA #$D6
+	STA $D400
+	LDA #$1C
+	STA $D401
+	LDA #$00
+	STA $D402
+	LDA #$06
+	STA $D403
+	LDA #$41
+	STA $D404
+	RTS
+PLAY	INC CNT
+	LDA CNT
+	AND #$07
+	STA $D415
+	INC $D416
+	INC $D416
+	LDA CNT
+	CMP #$40
+	BNE DONE
+	LDA #$2F
+	STA $D418
+	LDA #$81
+	STA $D417
+DONE	RTS
+CNT	.DB 0
+`},
+	// Played by a CIA timer interrupt: the init routine sets the timer,
+	// the play routine speeds it up after 40 calls
+	{"cia", 1, `
+	.ORG $1000
+	JMP INIT
+	JMP PLAY
+INIT	LDA #$25
+	STA $DC04
+	LDA #$40
+	STA $DC05
+	LDA #$0F
+	STA $D418
+	LDA #$00
+	STA $D405
+	STA CNT
+	STA IDX
+	LDA #$A8
+	STA $D406
+	RTS
+PLAY	INC CNT
+	LDA CNT
+	CMP #40
+	BNE NOSPEED
+	LDA #$00
+	STA $DC04
+	LDA #$20
+	STA $DC05
+	LDA CNT
+NOSPEED	AND #$03
+	BNE NONEW
+	LDX IDX
+	LDA NOTELO,X
+	STA $D400
+	LDA NOTEHI,X
+	STA $D401
+	LDA #$11
+	STA $D404
+	INX
+	TXA
+	AND #$03
+	STA IDX
+	RTS
+NONEW	CMP #$02
+	BNE DONE
+	LDA #$10
+	STA $D404
+DONE	RTS
+CNT	.DB 0
+IDX	.DB 0
+NOTELO	.DB $68,$F7,$D1,$68
+NOTEHI	.DB $08,$0A,$0D,$11
+`},
+}
+
+// goldenTune assembles a synthetic tune and returns it as a PSID file
+func goldenTune(t *testing.T, name string, speed uint32, src string) []byte {
+	a, _, err := asm.Assemble(strings.NewReader(src), name, 0x1000, io.Discard, 0)
+	if err != nil {
+		t.Fatalf("assembling %s: %v %v", name, err, a.Errors)
+	}
+	header := &psid.PSIDHeader{
+		MagicID:     [4]byte{'P', 'S', 'I', 'D'},
+		Version:     2,
+		LoadAddress: 0x1000,
+		InitAddress: 0x1000,
+		PlayAddress: 0x1003,
+		Songs:       1,
+		StartSong:   1,
+		Speed:       speed,
+	}
+	psid.SetText(&header.Name, name)
+	psid.SetText(&header.Author, "siddump")
+	var buf bytes.Buffer
+	if err := header.WritePSID(&buf, a.Code); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// goldenSettings are the default options, playing for two seconds
+func goldenSettings() *Settings {
+	opt := NewSettings()
+	opt.Basenote = 0xb0
+	opt.Lowres = 1
+	opt.Oldnotefactor = 1
+	opt.Seconds = 2
+	return opt
+}
+
+// TestGolden dumps every synthetic tune with all output modes and compares
+// the files written and the log with the ones in testdata/golden. Run
+// "go test -run TestGolden -update" to accept changed output.
+func TestGolden(t *testing.T) {
+	for _, tune := range goldenTunes {
+		t.Run(tune.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sidName := filepath.Join(dir, tune.name+".sid")
+			if err := os.WriteFile(sidName, goldenTune(t, tune.name, tune.speed, tune.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			outDir := filepath.Join(dir, "out")
+			if err := os.Mkdir(outDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			opt := goldenSettings()
+			for _, mode := range goldenModes {
+				ext := ".txt"
+				if e := decoders.Extension(mode); e != "" {
+					ext = e
+				}
+				opt.Outputs = append(opt.Outputs, OutputSpec{Mode: mode, Path: filepath.Join(outDir, fmt.Sprintf("mode%d%s", mode, ext))})
+			}
+			opt.Heatmap = filepath.Join(outDir, "heatmap.png")
+
+			d, err := NewDumper(opt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var log bytes.Buffer
+			if err := d.Dump(context.Background(), sidName, 0, io.Discard, &log, ""); err != nil {
+				t.Fatal(err)
+			}
+			logText := strings.ReplaceAll(log.String(), outDir+string(filepath.Separator), "")
+			if err := os.WriteFile(filepath.Join(outDir, "log.txt"), []byte(logText), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(outDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "golden", tune.name)
+			for _, entry := range entries {
+				got, err := os.ReadFile(filepath.Join(outDir, entry.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, filepath.Join(golden, entry.Name()), got)
+			}
+		})
+	}
+}
+
+// TestDumpNoFrames dumps a tune that loops before the first frame shown,
+// so the decoders get no frames at all
+func TestDumpNoFrames(t *testing.T) {
+	dir := t.TempDir()
+	sidName := filepath.Join(dir, "vibrato.sid")
+	tune := goldenTunes[2]
+	if err := os.WriteFile(sidName, goldenTune(t, tune.name, tune.speed, tune.src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opt := goldenSettings()
+	opt.Firstframe = 1000
+	opt.Loop = 1
+	for _, mode := range goldenModes {
+		opt.Outputs = append(opt.Outputs, OutputSpec{Mode: mode, Path: filepath.Join(dir, fmt.Sprintf("mode%d", mode))})
+	}
+	opt.Heatmap = filepath.Join(dir, "heatmap.png")
+
+	d, err := NewDumper(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	if err := d.Dump(context.Background(), sidName, 0, io.Discard, &log, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Piano roll: no frames, not written\n", "Heatmap: no frames, not written\n"} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("log does not contain %q:\n%s", want, log.String())
+		}
+	}
+	// The images would be empty and are not written
+	for _, name := range []string{"mode7", "mode7.svg", "heatmap.png"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("%s: got %v, want it not written", name, err)
+		}
+		if strings.Contains(log.String(), name+"\n") {
+			t.Errorf("log reports %s as written", name)
+		}
+	}
+}
+
+// TestDumpMUS dumps the notes of a Compute's Sidplayer file without any
+// options, so without a Sidplayer routine
+func TestDumpMUS(t *testing.T) {
+	sidName := filepath.Join(t.TempDir(), "test.mus")
+	mus := []byte{
+		0x00, 0x09, 0x06, 0x00, 0x02, 0x00, 0x02, 0x00,
+		0x0C, 0x21, 0x0C, 0x26, 0x01, 0x4F, // C-4, A-4, HLT
+		0x01, 0x4F, 0x01, 0x4F,
+	}
+	if err := os.WriteFile(sidName, mus, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opt := goldenSettings()
+	opt.Outputs = []OutputSpec{{Mode: 0}}
+	d, err := NewDumper(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, log bytes.Buffer
+	if err := d.Dump(context.Background(), sidName, 0, &out, &log, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"|     0 | 1167 (C-4 B0) 11 ", "|    32 | 1D45 (A-4 B9) 11 "} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output does not contain %q", want)
+		}
+	}
+}
+
+// checkGolden compares output with a golden file, or rewrites the golden
+// file with -update
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if bytes.Equal(got, want) {
+		return
+	}
+	switch filepath.Ext(path) {
+	case ".txt", ".html", ".svg":
+		line, g, w := firstLineDiff(string(got), string(want))
+		t.Errorf("%s differs at line %d\n got %q\nwant %q", path, line, g, w)
+	default:
+		t.Errorf("%s differs: %d bytes, want %d", path, len(got), len(want))
+	}
+}
+
+// firstLineDiff returns the number and the text of the first differing
+// line of two texts
+func firstLineDiff(got, want string) (int, string, string) {
+	gotLines := strings.Split(got, "\n")
+	wantLines := strings.Split(want, "\n")
+	for i := 0; ; i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w || i >= len(gotLines) || i >= len(wantLines) {
+			return i + 1, g, w
+		}
+	}
+}
@@ -4,136 +4,108 @@ package main
 // od -h sidtune.dmp | less
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
-const MAX_INSTR uint16 = 0xFFFF
+// Commands with their usage and number of arguments
+var commands = map[string]struct {
+	minArgs, maxArgs int
+	usage            string
+}{
+	"":         {1, 1, "[options] <sidfile>"},
+	"batch":    {2, 2, "batch [options] <sid directory> <output directory>"},
+	"debug":    {1, 1, "debug [options] <sidfile>"},
+	"relocate": {3, 3, "relocate [options] <sidfile> <address> <outfile>"},
+	"edit":     {1, 2, "edit [options] <sidfile> [outfile]"},
+	"pack":     {2, 2, "pack [options] <prgfile> <outfile>"},
+	"diff":     {2, 2, "diff [options] <sidfile or .dmp> <sidfile or .dmp>"},
+}
 
 func main() {
-	// opt := SidOutputSettings{}
-	opt := NewSidOutputSettings()
-	header := NewPSID()
-	var frame int = 0
+	opt := NewSettings()
+
+	// The first argument may be a command, e.g. siddump batch [options]
+	// <dir> <outdir>
+	args := os.Args[1:]
+	command := ""
+	if _, ok := commands[firstArg(args)]; ok && len(args) > 0 {
+		command = args[0]
+		args = args[1:]
+	}
 
 	// Parse arguments
-	opt.ParseArgs()
-
-	if len(flag.Args()) == 0 {
-		fmt.Println("Usage: go run main.go [options] <sidfile>")
+	opt.ParseArgs(args)
+	outputs, err := ParseOutputs(opt.DecoderOutput)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
 		os.Exit(1)
 	}
+	opt.Outputs = outputs
 
 	if opt.Usage == 1 {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	// get file name of sid tune
-	sidName := flag.Arg(0)
-
-	// Try to open SID file
-	file, err := os.Open(sidName)
-	check(err)
-	defer file.Close()
-
-	// Load PSID header
-	err = header.LoadPSIDHeader(file)
-	check(err)
-
-	header.PrintPSIDVitals()
-
-	// Load PSID data into cpu memory
-	cpu := NewCpu()
-	err = header.LoadPSIDData(cpu, file)
-	check(err)
-
-	// Print info and run initroutine
-	fmt.Printf("Load address: $%04X Init address: $%04X Play address: $%04X\n", header.LoadAddress, header.InitAddress, header.PlayAddress)
-	fmt.Printf("Calling initroutine with subtune %d\n", opt.Subtune)
-	cpu.Mem.StoreByte(0x01, 0x37)
-	Init(cpu, header.InitAddress, uint8(opt.Subtune), 0, 0)
-	instr := 0
-
-	for Run(cpu) == 1 {
-		IncrementValueAtAddress(cpu, 0xD012)
-		if (cpu.Mem.LoadByte(0xD012) == 0) || (((cpu.Mem.LoadByte(0xD011) & 0x80) != 0) && (cpu.Mem.LoadByte(0xd012) >= 0x38)) {
-			tmp := cpu.Mem.LoadByte(0xD011)
-			tmp ^= 0x80
-			cpu.Mem.StoreByte(0xD011, tmp)
-			cpu.Mem.StoreByte(0xD012, 0x0)
-		}
-		instr += 1
-
-		if instr > int(MAX_INSTR) {
-			fmt.Println("Warning: CPU executed a high number of instructions in init, breaking")
-			break
-		}
+	cmd := commands[command]
+	if len(flag.Args()) < cmd.minArgs || (command != "" && len(flag.Args()) > cmd.maxArgs) {
+		fmt.Println("Usage: go run main.go " + cmd.usage)
+		os.Exit(1)
 	}
 
-	if header.PlayAddress == 0 {
-		fmt.Println("Warning: SID has play address 0, reading from interrupt vector instead")
-		if cpu.Mem.LoadByte(0x01)&0x07 == 0x5 {
-			header.PlayAddress = uint16(cpu.Mem.LoadByte(0xFFFE)) | (uint16(cpu.Mem.LoadByte(0xFFFF)) << 8)
-		} else {
-			header.PlayAddress = uint16(cpu.Mem.LoadByte(0x314)) | (uint16(cpu.Mem.LoadByte(0x315)) << 8)
-		}
-		fmt.Printf("New play address is $%04X\n", header.PlayAddress)
+	dumper, err := NewDumper(opt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
 	}
 
-	currentSid := NewSID()
-
-	// Create requested output struct type
-	screenSidReg := &ScreenOutputSidRegisters{Options: opt, SidState: currentSid}
-	screenNotes := &ScreenOutputWithNotes{Options: opt, SidState: currentSid}
-	fileSidDtDump := &BinFileRegistersAndDtDumps{Options: opt, SidState: currentSid, fileName: "sidtune.dmp"}
-
-	output := &ActiveDecoder{}
+	// get file name of sid tune
+	sidName := flag.Arg(0)
 
-	switch opt.DecoderOutput {
-	case 1:
-		output.SetOutput(screenSidReg)
-	case 4:
-		output.SetOutput(fileSidDtDump)
+	switch command {
+	case "batch":
+		err = NewBatch(dumper, opt.Workers, os.Stdout).Run(flag.Arg(0), flag.Arg(1))
+	case "debug":
+		err = dumper.Debug(sidName, opt.Subtune, os.Stdin, os.Stdout)
+	case "relocate":
+		err = dumper.Relocate(sidName, flag.Arg(1), flag.Arg(2), os.Stdout)
+	case "edit":
+		err = dumper.Edit(sidName, flag.Arg(1), os.Stdout)
+	case "pack":
+		err = dumper.Pack(sidName, flag.Arg(1), os.Stdout)
+	case "diff":
+		err = dumper.Compare(sidName, flag.Arg(1), os.Stdout)
 	default:
-		output.SetOutput(screenNotes)
+		err = dumper.Dump(context.Background(), sidName, opt.Subtune, os.Stdout, os.Stderr, outputBase(opt.Output, sidName))
 	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
 
-	fmt.Printf("Calling playroutine for %d frames, starting from frame %d\n", opt.Seconds*50, opt.Firstframe)
-
-	output.PreProcess()
-
-	for frame < opt.Firstframe+opt.Seconds*50 {
-		// Run the playroutine
-		instr = 0
-		Init(cpu, header.PlayAddress, 0, 0, 0)
-
-		for Run(cpu) == 1 {
-			instr += 1
-
-			if instr > int(MAX_INSTR) {
-				fmt.Println("Warning: CPU executed a high number of instructions in init, breaking")
-				break
-			}
-
-			// Test for jump into Kernal interrupt handler exit
-			if ((cpu.Mem.LoadByte(0x01) & 0x07) != 0x5) && (cpu.Reg.PC == 0xEA31 || cpu.Reg.PC == 0xEA81) {
-				break
-			}
-		}
-
-		// // Update Sid with latest values from memory
-		currentSid.CopyFromCpu(cpu)
-
-		// Frame display
-		if frame >= opt.Firstframe {
-			output.ProcessFrame(frame, cpu.Cycles)
-		}
-
-		// Advance to next frame
-		frame++
+// firstArg returns the first argument, or an empty string without
+// arguments
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
 	}
+	return args[0]
+}
 
-	output.PostProcess()
+// outputBase returns the base name of the output files of a tune
+func outputBase(output string, sidName string) string {
+	tuneBase := strings.TrimSuffix(sidName, filepath.Ext(sidName))
+	switch {
+	case output == "":
+		return tuneBase
+	case strings.HasSuffix(output, "/") || strings.HasSuffix(output, string(filepath.Separator)):
+		return filepath.Join(output, filepath.Base(tuneBase))
+	}
+	return output
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Pack wraps a C64 program file or raw binary into a PSID file, with the
+// addresses and header fields given in the options
+func (d *Dumper) Pack(prgName string, outName string, log io.Writer) error {
+	tune, err := d.openProgram(prgName)
+	if err != nil {
+		return err
+	}
+	if err := applyEdit(tune.Header, &d.Options.Edit); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := tune.Header.WritePSID(&buf, tune.Data); err != nil {
+		return err
+	}
+	if err := os.WriteFile(outName, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	tune.Header.PrintPSIDVitals(log)
+	fmt.Fprintf(log, "Wrote %s\n", outName)
+	return nil
+}
@@ -0,0 +1,67 @@
+package player
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// ReadFile reads a whole tune file. "-" is standard input, a name like
+// archive.zip/path/in/archive.sid is a file inside a zip archive, and
+// files ending in .gz are decompressed.
+func ReadFile(fileName string) ([]byte, error) {
+	var r io.Reader
+	switch archive, entry, ok := splitZipPath(fileName); {
+	case fileName == "-":
+		r = os.Stdin
+	case ok:
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		f, err := zr.Open(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", archive, err)
+		}
+		defer f.Close()
+		r = f
+	default:
+		f, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if strings.HasSuffix(strings.ToLower(fileName), ".gz") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	return io.ReadAll(r)
+}
+
+// splitZipPath splits a path into a zip archive and a file in it, when a
+// part of the path ending in .zip is a file
+func splitZipPath(fileName string) (archive string, entry string, ok bool) {
+	lower := strings.ToLower(fileName)
+	for i := 0; ; {
+		n := strings.Index(lower[i:], ".zip/")
+		if n < 0 {
+			return "", "", false
+		}
+		i += n + len(".zip")
+		if info, err := os.Stat(fileName[:i]); err == nil && info.Mode().IsRegular() {
+			return fileName[:i], fileName[i+1:], fs.ValidPath(fileName[i+1:])
+		}
+	}
+}
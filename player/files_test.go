@@ -0,0 +1,143 @@
+package player
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"siddump/psid"
+)
+
+// testCode is a tune at $1000: the init routine sets the volume, the play
+// routine counts up the frequency of voice 1
+var testCode = []byte{
+	0x4C, 0x06, 0x10, // JMP $1006
+	0x4C, 0x0C, 0x10, // JMP $100C
+	0xA9, 0x0F, // LDA #$0F
+	0x8D, 0x18, 0xD4, // STA $D418
+	0x60,             // RTS
+	0xEE, 0x00, 0xD4, // INC $D400
+	0x60, // RTS
+}
+
+// testTune returns a PSID file of code loaded at $1000
+func testTune(t *testing.T, code []byte, flags uint16) []byte {
+	t.Helper()
+	header := &psid.PSIDHeader{
+		MagicID:     [4]byte{'P', 'S', 'I', 'D'},
+		Version:     2,
+		LoadAddress: 0x1000,
+		InitAddress: 0x1000,
+		PlayAddress: 0x1003,
+		Songs:       1,
+		StartSong:   1,
+		Flags:       flags,
+	}
+	var buf bytes.Buffer
+	if err := header.WritePSID(&buf, code); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipped returns a zip archive holding files
+func zipped(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	sid := testTune(t, testCode, 0)
+	files := map[string][]byte{
+		"tune.sid":    sid,
+		"tune.sid.gz": gzipped(t, sid),
+		"HVSC.zip": zipped(t, map[string][]byte{
+			"C64Music/MUSICIANS/T/tune.sid":    sid,
+			"C64Music/MUSICIANS/T/tune.sid.gz": gzipped(t, sid),
+		}),
+		"broken.sid.gz": sid,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		file string
+		err  string
+	}{
+		{"plain file", "tune.sid", ""},
+		{"gzip", "tune.sid.gz", ""},
+		{"zip entry", "HVSC.zip/C64Music/MUSICIANS/T/tune.sid", ""},
+		{"gzip in zip", "HVSC.zip/C64Music/MUSICIANS/T/tune.sid.gz", ""},
+		{"missing zip entry", "HVSC.zip/C64Music/missing.sid", "HVSC.zip"},
+		{"not gzip", "broken.sid.gz", "broken.sid.gz"},
+		{"missing file", "missing.sid", "missing.sid"},
+	}
+	for _, test := range tests {
+		tune, err := Open(filepath.Join(dir, test.file))
+		switch {
+		case test.err != "":
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want one naming %s", test.name, err, test.err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case !bytes.Equal(tune.Data, testCode) || !bytes.Equal(tune.File, sid):
+			t.Errorf("%s: tune data differs", test.name)
+		}
+	}
+}
+
+func TestOpenStdin(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(name, testTune(t, testCode, 0), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdin := os.Stdin
+	os.Stdin = f
+	defer func() { os.Stdin = stdin }()
+
+	tune, err := Open("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tune.Data, testCode) {
+		t.Errorf("data % X, want % X", tune.Data, testCode)
+	}
+}
@@ -0,0 +1,83 @@
+package player
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"time"
+
+	"siddump/psid"
+	"siddump/sid"
+)
+
+// Frame is a snapshot of the SID state after the play routine of one frame
+type Frame struct {
+	Number int
+	Cycles uint64
+
+	// Playback time at the start of the frame and the time until the next
+	// frame, from the CIA timer or the vertical blank rate of the clock
+	Time     time.Duration
+	Duration time.Duration
+
+	Sid sid.Sid
+}
+
+// Frames returns an iterator over the remaining frames of the playback, up
+// to Length. The iteration stops when ctx is cancelled, Err then returns
+// the reason.
+func (p *Playback) Frames(ctx context.Context) iter.Seq[Frame] {
+	return func(yield func(Frame) bool) {
+		for {
+			if err := ctx.Err(); err != nil {
+				p.err = err
+				return
+			}
+			if p.Length != 0 && p.elapsed >= p.Length {
+				return
+			}
+			if !p.Next() {
+				return
+			}
+
+			f := Frame{Number: p.Frame, Cycles: p.Cycles(), Time: p.elapsed, Duration: p.frameDuration(), Sid: *p.Sid}
+			p.elapsed += f.Duration
+			if !yield(f) {
+				return
+			}
+		}
+	}
+}
+
+// FormatTime formats a playback time in minutes:seconds.hundredths format
+func FormatTime(t time.Duration) string {
+	cs := int(t / (10 * time.Millisecond))
+	return fmt.Sprintf("%01d:%02d.%02d", cs/6000, (cs/100)%60, cs%100)
+}
+
+// CPU clock frequencies in Hz
+const (
+	PAL_CLOCK  = 985248
+	NTSC_CLOCK = 1022727
+)
+
+// frameDuration returns the time until the next call of the play routine:
+// the CIA timer counts CPU cycles, without it the play routine is called
+// on every vertical blank
+func (p *Playback) frameDuration() time.Duration {
+	clock, rate := PAL_CLOCK, 50
+	if p.Tune.Header.Clock() == psid.CLOCK_NTSC {
+		clock, rate = NTSC_CLOCK, 60
+	}
+	timer := uint16(p.CPU.Mem.LoadByte(0xDC05))<<8 | uint16(p.CPU.Mem.LoadByte(0xDC04))
+	if timer == 0 {
+		return divRound(time.Second, rate)
+	}
+	return divRound(time.Duration(timer)*time.Second, clock)
+}
+
+// divRound divides a time rounding to the nearest nanosecond, so the
+// frames of a second add up to at least a second
+func divRound(t time.Duration, n int) time.Duration {
+	return (t + time.Duration(n/2)) / time.Duration(n)
+}
@@ -0,0 +1,120 @@
+package player
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"siddump/psid"
+)
+
+// testCIACode is testCode with the CIA timer set to $4025 cycles by the
+// init routine
+var testCIACode = []byte{
+	0x4C, 0x06, 0x10, // JMP $1006
+	0x4C, 0x16, 0x10, // JMP $1016
+	0xA9, 0x25, // LDA #$25
+	0x8D, 0x04, 0xDC, // STA $DC04
+	0xA9, 0x40, // LDA #$40
+	0x8D, 0x05, 0xDC, // STA $DC05
+	0xA9, 0x0F, // LDA #$0F
+	0x8D, 0x18, 0xD4, // STA $D418
+	0x60,             // RTS
+	0xEE, 0x00, 0xD4, // INC $D400
+	0x60, // RTS
+}
+
+func TestFrames(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		flags    uint16
+		duration time.Duration
+	}{
+		{"PAL vertical blank", testCode, psid.CLOCK_PAL << 2, 20 * time.Millisecond},
+		{"NTSC vertical blank", testCode, psid.CLOCK_NTSC << 2, divRound(time.Second, 60)},
+		{"PAL CIA timer", testCIACode, psid.CLOCK_PAL << 2, divRound(0x4025*time.Second, PAL_CLOCK)},
+		{"NTSC CIA timer", testCIACode, psid.CLOCK_NTSC << 2, divRound(0x4025*time.Second, NTSC_CLOCK)},
+		{"unknown clock", testCIACode, 0, divRound(0x4025*time.Second, PAL_CLOCK)},
+	}
+	for _, test := range tests {
+		tune, err := Read("test.sid", bytes.NewReader(testTune(t, test.code, test.flags)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		playback, err := tune.Play(0, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for f := range playback.Frames(context.Background()) {
+			if f.Number != n || f.Duration != test.duration || f.Time != time.Duration(n)*test.duration {
+				t.Errorf("%s: frame %d: number %d, time %v, duration %v, want duration %v", test.name, n, f.Number, f.Time, f.Duration, test.duration)
+			}
+			if f.Sid.Register[0] != uint8(n+1) {
+				t.Errorf("%s: frame %d: frequency $%02X, want $%02X", test.name, n, f.Sid.Register[0], n+1)
+			}
+			n++
+		}
+		if err := playback.Err(); err != nil || n != 3 {
+			t.Errorf("%s: %d frames, error %v", test.name, n, err)
+		}
+	}
+}
+
+func TestFramesCancel(t *testing.T) {
+	tune, err := Read("test.sid", bytes.NewReader(testTune(t, testCode, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	playback, err := tune.Play(0, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	for range playback.Frames(ctx) {
+		n++
+		if n == 5 {
+			cancel()
+		}
+	}
+	if n != 5 || playback.Err() != context.Canceled {
+		t.Errorf("%d frames, error %v, want 5 frames and %v", n, playback.Err(), context.Canceled)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	code := []byte{
+		0x4C, 0x06, 0x10, // JMP $1006
+		0x4C, 0x0D, 0x10, // JMP $100D
+		0xA9, 0x0F, // LDA #$0F
+		0x8D, 0x18, 0xD4, // STA $D418
+		0xD0, 0xFE, // BNE *
+		0xEE, 0x00, 0xD4, // INC $D400
+		0xD0, 0xFE, // BNE *
+	}
+	tune, err := Read("test.sid", bytes.NewReader(testTune(t, code, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	tune.Log = &log
+	playback, err := tune.Play(0, 1)
+	if err != nil {
+		t.Fatalf("init running too long: %v", err)
+	}
+	if !playback.Next() || playback.Err() != nil {
+		t.Fatalf("play running too long: %v", playback.Err())
+	}
+	if playback.Sid.Register[0] != 1 || playback.Sid.Register[24] != 0x0F {
+		t.Errorf("registers % X", playback.Sid.Register[:25])
+	}
+	want := "Warning: CPU executed a high number of instructions in init, breaking\n" +
+		"Warning: CPU executed a high number of instructions in play, breaking\n"
+	if log.String() != want {
+		t.Errorf("log %q, want %q", log.String(), want)
+	}
+}
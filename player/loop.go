@@ -0,0 +1,119 @@
+package player
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"time"
+
+	"siddump/sid"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// Number of seconds a tune must stay silent to be considered ended
+const SILENCE_SECONDS = 5
+
+// LoopDetector fingerprints the machine state after every frame to find
+// the point where a tune loops back to an earlier state or goes silent.
+type LoopDetector struct {
+	seen     map[uint64]int
+	mem      []byte
+	released [3]time.Duration
+	silent   int
+	// Time spent silent, and the start time of every frame checked
+	silentTime time.Duration
+	times      []time.Duration
+
+	// Results, valid once Check has returned true. The times are the
+	// playback times of the frames.
+	LoopStart      int
+	LoopLength     int
+	SilentFrom     int
+	LoopStartTime  time.Duration
+	LoopLengthTime time.Duration
+	SilentFromTime time.Duration
+}
+
+func NewLoopDetector() *LoopDetector {
+	d := &LoopDetector{seen: make(map[uint64]int), mem: make([]byte, 0x10000), SilentFrom: -1}
+	return d
+}
+
+// Check adds the state after playing a frame and reports whether the tune
+// has looped or ended. The frames must be checked in order.
+func (d *LoopDetector) Check(c *cpu.CPU, s *sid.Sid, f *Frame) bool {
+	frame := f.Number
+	end := f.Time + f.Duration
+	d.times = append(d.times, f.Time)
+	if d.isSilent(s, f.Duration) {
+		d.silent++
+		d.silentTime += f.Duration
+		if d.silentTime >= SILENCE_SECONDS*time.Second {
+			d.SilentFrom = frame - d.silent + 1
+			d.SilentFromTime = end - d.silentTime
+			return true
+		}
+	} else {
+		d.silent = 0
+		d.silentTime = 0
+	}
+
+	// All RAM plus the SID registers and the CIA timer, leaving out the
+	// raster registers and other I/O the player does not control
+	c.Mem.LoadBytes(0, d.mem)
+	for adr := 0xD000; adr < 0xE000; adr++ {
+		if (adr < 0xD400 || adr > 0xD418) && adr != 0xDC04 && adr != 0xDC05 {
+			d.mem[adr] = 0
+		}
+	}
+
+	h := fnv.New64a()
+	h.Write(d.mem)
+	sum := h.Sum64()
+
+	if prev, ok := d.seen[sum]; ok {
+		d.LoopStart = prev + 1
+		d.LoopLength = frame - prev
+		d.LoopStartTime = d.times[len(d.times)-d.LoopLength]
+		d.LoopLengthTime = end - d.LoopStartTime
+		return true
+	}
+	d.seen[sum] = frame
+	return false
+}
+
+// isSilent reports whether no voice can be heard in the frame
+func (d *LoopDetector) isSilent(s *sid.Sid, duration time.Duration) bool {
+	if s.Register[24]&0xF == 0 {
+		return true
+	}
+
+	silent := true
+	for i := 0; i < 3; i++ {
+		v := &s.Channel[i]
+		if v.Wave&1 == 1 {
+			d.released[i] = 0
+		} else {
+			d.released[i] += duration
+		}
+		if v.Wave >= 0x10 && v.Wave&0x08 == 0 && d.released[i] <= time.Duration(sid.DecayMs[v.ADSR&0xF])*time.Millisecond {
+			silent = false
+		}
+	}
+	return silent
+}
+
+// PrintResult prints the intro and loop lengths, or the point where the
+// tune went silent.
+func (d *LoopDetector) PrintResult(w io.Writer) {
+	switch {
+	case d.SilentFrom >= 0:
+		fmt.Fprintf(w, "Song end: silent from frame %d (%s)\n", d.SilentFrom, FormatTime(d.SilentFromTime))
+	case d.LoopLength > 0:
+		fmt.Fprintf(w, "Song loop: intro length %d frames (%s), loop length %d frames (%s)\n",
+			d.LoopStart, FormatTime(d.LoopStartTime), d.LoopLength, FormatTime(d.LoopLengthTime))
+	default:
+		fmt.Fprintln(w, "No song loop or end detected")
+	}
+}
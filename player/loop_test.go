@@ -0,0 +1,116 @@
+package player
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"siddump/psid"
+)
+
+// loopCode plays with the CIA timer at $C000 cycles when the init routine
+// is entered at $1006, or on the vertical blank from $100B. The play
+// routine changes a register every frame, so the tune loops after 256
+// frames. With gate set the first voice sounds, otherwise the tune is
+// silent.
+func loopCode(gate byte) []byte {
+	return []byte{
+		0x4C, 0x06, 0x10, // JMP $1006
+		0x4C, 0x16, 0x10, // JMP $1016
+		0xA9, 0xC0, // LDA #$C0
+		0x8D, 0x05, 0xDC, // STA $DC05
+		0xA9, 0x0F, // LDA #$0F
+		0x8D, 0x18, 0xD4, // STA $D418
+		0xA9, gate, // LDA #gate
+		0x8D, 0x04, 0xD4, // STA $D404
+		0x60,             // RTS
+		0xEE, 0x00, 0xD4, // INC $D400
+		0x60, // RTS
+	}
+}
+
+func TestLoopDetector(t *testing.T) {
+	cia := divRound(0xC000*time.Second, PAL_CLOCK)
+	tests := []struct {
+		name   string
+		init   uint16
+		gate   byte
+		frames int
+		// Silent from frame 0 or looping at frame 1, and the length
+		silent bool
+		length time.Duration
+	}{
+		{"silent on vertical blank", 0x100B, 0x10, 250, true, 0},
+		{"silent on CIA timer", 0x1006, 0x10, 101, true, 0},
+		{"loop on vertical blank", 0x100B, 0x11, 257, false, 256 * 20 * time.Millisecond},
+		{"loop on CIA timer", 0x1006, 0x11, 257, false, 256 * cia},
+	}
+	for _, test := range tests {
+		file := testTune(t, loopCode(test.gate), 0)
+		tune, err := Read("test.sid", bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tune.Header.InitAddress = test.init
+		playback, err := tune.Play(0, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := NewLoopDetector()
+		n := 0
+		for f := range playback.Frames(context.Background()) {
+			n++
+			if d.Check(playback.CPU, playback.Sid, &f) {
+				break
+			}
+		}
+		if n != test.frames {
+			t.Errorf("%s: stopped after %d frames, want %d", test.name, n, test.frames)
+		}
+		if test.silent {
+			if d.SilentFrom != 0 || d.SilentFromTime != 0 {
+				t.Errorf("%s: silent from frame %d (%v)", test.name, d.SilentFrom, d.SilentFromTime)
+			}
+			continue
+		}
+		if d.LoopStart != 1 || d.LoopLength != 256 || d.LoopLengthTime != test.length {
+			t.Errorf("%s: loop from frame %d for %d frames (%v), want 1, 256 (%v)",
+				test.name, d.LoopStart, d.LoopLength, d.LoopLengthTime, test.length)
+		}
+	}
+}
+
+func TestPlaybackLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   []byte
+		flags  uint16
+		length time.Duration
+		frames int
+	}{
+		{"PAL", testCode, 0, time.Second, 50},
+		{"NTSC", testCode, psid.CLOCK_NTSC << 2, time.Second, 60},
+		// $4025 cycles are 16.7 ms
+		{"CIA timer", testCIACode, 0, time.Second, 60},
+		{"partial frame", testCode, 0, 990 * time.Millisecond, 50},
+	}
+	for _, test := range tests {
+		tune, err := Read("test.sid", bytes.NewReader(testTune(t, test.code, test.flags)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		playback, err := tune.Play(0, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		playback.Length = test.length
+		n := 0
+		for range playback.Frames(context.Background()) {
+			n++
+		}
+		if n != test.frames {
+			t.Errorf("%s: %d frames, want %d", test.name, n, test.frames)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package player
+
+import (
+	"math"
+
+	"siddump/psid"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+// Frames of a whole note, used for all tunes as tempo commands are not
+// interpreted. A quarter note is 32 frames, the utility duration is a
+// quarter note.
+const MUS_WHOLE_FRAMES = 128
+
+// Sound of all voices: a triangle with full sustain and a short release,
+// gated off in the last frame of a note unless it is tied to the next one
+const (
+	MUS_WAVEFORM = 0x10
+	MUS_AD       = 0x00
+	MUS_SR       = 0xF2
+)
+
+// musInterpreter plays Sidplayer data without the Sidplayer routine. Only
+// the notes, rests and ties of the voices are played, other commands are
+// skipped.
+type musInterpreter struct {
+	voices [3]musVoice
+}
+
+// musVoice is the position in the data of a voice and the note playing
+type musVoice struct {
+	data   []byte
+	pos    int
+	left   int
+	freq   uint16
+	gate   bool
+	tie    bool
+	halted bool
+}
+
+func newMUSInterpreter(data []byte) (*musInterpreter, error) {
+	mus, err := psid.ParseMUS(data)
+	if err != nil {
+		return nil, err
+	}
+	m := &musInterpreter{}
+	for i := range m.voices {
+		m.voices[i].data = mus.Voices[i]
+	}
+	return m, nil
+}
+
+// init sets up the sound of the voices and the volume
+func (m *musInterpreter) init(mem cpu.Memory) {
+	for i := uint16(0); i < 3; i++ {
+		mem.StoreByte(0xD402+7*i, 0x00)
+		mem.StoreByte(0xD403+7*i, 0x08)
+		mem.StoreByte(0xD405+7*i, MUS_AD)
+		mem.StoreByte(0xD406+7*i, MUS_SR)
+	}
+	mem.StoreByte(0xD418, 0x0F)
+}
+
+// play plays one frame of every voice
+func (m *musInterpreter) play(mem cpu.Memory) {
+	for i := range m.voices {
+		v := &m.voices[i]
+		if v.halted {
+			continue
+		}
+		if v.left == 0 {
+			v.next()
+		}
+		v.left--
+		if v.left == 0 && !v.tie {
+			v.gate = false
+		}
+
+		offset := uint16(7 * i)
+		control := uint8(MUS_WAVEFORM)
+		if v.gate {
+			control |= 1
+		}
+		mem.StoreByte(0xD400+offset, uint8(v.freq))
+		mem.StoreByte(0xD401+offset, uint8(v.freq>>8))
+		mem.StoreByte(0xD404+offset, control)
+	}
+}
+
+// next reads the commands of a voice up to the next note or rest, or to the
+// end of the voice
+func (v *musVoice) next() {
+	for v.pos+1 < len(v.data) {
+		b0, b1 := v.data[v.pos], v.data[v.pos+1]
+		v.pos += 2
+		note, ok := psid.DecodeMUSNote(b0, b1)
+		if !ok {
+			if uint16(b0)<<8|uint16(b1) == psid.MUS_HLT {
+				break
+			}
+			continue
+		}
+
+		v.left = musFrames(note)
+		v.tie = note.Tie
+		v.gate = !note.Rest
+		if !note.Rest {
+			v.freq = musFrequency(note.Pitch)
+		}
+		return
+	}
+	v.halted = true
+	v.gate = false
+	v.left = 1
+}
+
+// musFrames returns the length of a note in frames, at least one
+func musFrames(note psid.MUSNote) int {
+	frames := MUS_WHOLE_FRAMES / 4
+	if note.Duration != psid.MUS_UTILITY {
+		frames = MUS_WHOLE_FRAMES >> (note.Duration - psid.MUS_WHOLE)
+	}
+	if note.Dotted {
+		frames = frames * 3 / 2
+	}
+	if note.Triplet {
+		frames = frames * 2 / 3
+	}
+	return max(frames, 1)
+}
+
+// musFrequency returns the SID frequency of a note, semitones above C-0,
+// with A-4 at 440 Hz on the PAL clock
+func musFrequency(pitch int) uint16 {
+	hz := 440 * math.Pow(2, float64(pitch-57)/12)
+	return uint16(min(math.Round(hz*(1<<24)/PAL_CLOCK), 0xFFFF))
+}
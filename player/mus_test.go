@@ -0,0 +1,77 @@
+package player
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A .mus file with a quarter C-4, a command, a tied eighth A-4, an eighth
+// A#4, a sixteenth rest and HLT on the first voice
+var testMUSFile = bytes.Join([][]byte{
+	{0x00, 0x09},
+	{0x0C, 0x00, 0x02, 0x00, 0x02, 0x00},
+	{0x0C, 0x21, 0x05, 0x12, 0x90, 0x26, 0x10, 0x66, 0x14, 0x00, 0x01, 0x4F},
+	{0x01, 0x4F},
+	{0x01, 0x4F},
+	[]byte("TEST\r"),
+}, nil)
+
+func TestMUSInterpreter(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "test.mus")
+	if err := os.WriteFile(fileName, testMUSFile, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tune, err := OpenMUS(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	tune.Log = &log
+	playback, err := tune.Play(0, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// First frame of every note and its frequency, the frames with the
+	// gate set and the control register
+	tests := []struct {
+		from, to int
+		freq     uint16
+		gateTo   int
+	}{
+		{0, 31, 0x1167, 30},  // C-4, released in the last frame
+		{32, 47, 0x1D45, 47}, // A-4 tied to the next note
+		{48, 63, 0x1F02, 62}, // A#4
+		{64, 71, 0x1F02, -1}, // rest
+		{72, 79, 0x1F02, -1}, // HLT
+	}
+	frames := 0
+	for f := range playback.Frames(context.Background()) {
+		frames++
+		for _, test := range tests {
+			if f.Number < test.from || f.Number > test.to {
+				continue
+			}
+			freq := uint16(f.Sid.Register[0]) | uint16(f.Sid.Register[1])<<8
+			control := uint8(MUS_WAVEFORM)
+			if f.Number <= test.gateTo {
+				control |= 1
+			}
+			if freq != test.freq || f.Sid.Register[4] != control {
+				t.Errorf("frame %d: frequency $%04X control $%02X, want $%04X $%02X", f.Number, freq, f.Sid.Register[4], test.freq, control)
+			}
+		}
+		if f.Sid.Register[24] != 0x0F || f.Sid.Register[11] != MUS_WAVEFORM {
+			t.Errorf("frame %d: volume $%02X, second voice $%02X", f.Number, f.Sid.Register[24], f.Sid.Register[11])
+		}
+	}
+	if err := playback.Err(); err != nil || frames != 80 {
+		t.Errorf("%d frames, error %v", frames, err)
+	}
+	if log.Len() == 0 {
+		t.Error("no warning about the interpreted data")
+	}
+}
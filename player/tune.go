@@ -0,0 +1,334 @@
+package player
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"siddump/c64"
+	"siddump/psid"
+	"siddump/sid"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+var ErrMUSEntry = errors.New("init or play address is outside the Sidplayer routine")
+
+// Tune is a loaded SID tune, ready to be played
+type Tune struct {
+	Header   *psid.PSIDHeader
+	FileName string
+
+	// The whole file, and the C64 data without the load address
+	File []byte
+	Data []byte
+
+	// Warnings of the emulation are written here, discarded when nil
+	Log io.Writer
+
+	// The Compute's Sidplayer routine, for tunes with Sidplayer data. The
+	// data is interpreted when it is nil.
+	MUSPlayer *MUSPlayer
+
+	// Warnings about the tune, written to Log when it is played
+	warnings []string
+
+	// Setup functions are called with the machine of every playback
+	// before the tune is loaded, to attach debuggers and tracers
+	Setup []func(c *cpu.CPU)
+}
+
+// Open loads a tune from a PSID file, see ReadFile for the names of files
+// in archives
+func Open(fileName string) (*Tune, error) {
+	file, err := ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return load(fileName, file)
+}
+
+// Read loads a tune from a PSID file read from r, the name is only used to
+// name the tune
+func Read(fileName string, r io.Reader) (*Tune, error) {
+	file, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return load(fileName, file)
+}
+
+func load(fileName string, file []byte) (*Tune, error) {
+	t := &Tune{Header: psid.NewPSID(), FileName: fileName, File: file}
+	r := bytes.NewReader(file)
+	if err := t.Header.LoadPSIDHeader(r); err != nil {
+		return nil, err
+	}
+	var err error
+	if t.Data, err = t.Header.LoadPSIDData(r); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// OpenProgram loads a tune from a C64 program file, or from a raw binary
+// when a load address is given. The tune gets a generated PSID header with
+// the file name as its name.
+func OpenProgram(fileName string, load uint16, init uint16, play uint16) (*Tune, error) {
+	file, err := ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tune{FileName: fileName}
+	r := bytes.NewReader(file)
+	if load != 0 {
+		t.Header, t.Data, err = psid.LoadBinary(r, load, init, play)
+	} else {
+		t.Header, t.Data, err = psid.LoadPRG(r, init, play)
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(fileName), filepath.Ext(fileName))
+	if len(name) > len(t.Header.Name) {
+		name = name[:len(t.Header.Name)]
+	}
+	psid.SetText(&t.Header.Name, name)
+
+	// The file is the tune as a PSID file
+	var buf bytes.Buffer
+	if err := t.Header.WritePSID(&buf, t.Data); err != nil {
+		return nil, err
+	}
+	t.File = buf.Bytes()
+	return t, nil
+}
+
+// MUSPlayer is a Compute's Sidplayer routine and its entry points, 0 when
+// not known
+type MUSPlayer struct {
+	Load uint16
+	Data []byte
+	Init uint16
+	Play uint16
+}
+
+// OpenMUS loads a Compute's Sidplayer .mus or .str file. It is played by
+// the Sidplayer routine when one is loaded with LoadMUSPlayer, otherwise
+// its notes are interpreted.
+//
+// Stereo tunes come as a .mus file for the first SID and a .str file of
+// the same name for the second one. Only one SID is emulated, so only the
+// part opened is played, on the first SID.
+func OpenMUS(fileName string) (*Tune, error) {
+	file, err := ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tune{FileName: fileName}
+	if t.Header, t.Data, err = psid.LoadMUS(bytes.NewReader(file)); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Header.WritePSID(&buf, t.Data); err != nil {
+		return nil, err
+	}
+	t.File = buf.Bytes()
+
+	base := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".str":
+		t.warnings = append(t.warnings, "Warning: .str files are the second SID part of a stereo Sidplayer tune, playing it on the first SID\n")
+	case ".mus":
+		for _, ext := range []string{".str", ".STR"} {
+			if _, err := os.Stat(base + ext); err == nil {
+				t.warnings = append(t.warnings, fmt.Sprintf("Warning: stereo Sidplayer tune, the second SID part %s is not played\n", base+ext))
+				break
+			}
+		}
+	}
+	return t, nil
+}
+
+// LoadMUSPlayer loads the Compute's Sidplayer routine, to play tunes with
+// Sidplayer data. It is read from a C64 program file, or from a PSID file
+// whose init and play addresses then become the ones of the tune.
+func (t *Tune) LoadMUSPlayer(fileName string) error {
+	file, err := ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	p := &MUSPlayer{}
+	r := bytes.NewReader(file)
+	if bytes.HasPrefix(file, []byte("PSID")) {
+		header := psid.NewPSID()
+		if err := header.LoadPSIDHeader(r); err != nil {
+			return err
+		}
+		if p.Data, err = header.LoadPSIDData(r); err != nil {
+			return err
+		}
+		p.Load, p.Init, p.Play = header.LoadAddress, header.InitAddress, header.PlayAddress
+	} else {
+		header, data, err := psid.LoadPRG(r, 0, 0)
+		if err != nil {
+			return err
+		}
+		p.Load, p.Data = header.LoadAddress, data
+	}
+
+	t.MUSPlayer = p
+	if p.Init != 0 {
+		t.Header.InitAddress = p.Init
+		t.Header.PlayAddress = p.Play
+	}
+	return nil
+}
+
+// inside reports whether the routine contains an address
+func (p *MUSPlayer) inside(addr uint16) bool {
+	return int(addr) >= int(p.Load) && int(addr) < int(p.Load)+len(p.Data)
+}
+
+// SongLength looks up the length of a subtune (0-based) in the song
+// length database
+func (t *Tune) SongLength(db *psid.SongLengths, subtune int) (float64, bool) {
+	return t.Header.SongLength(db, t.File, t.Data, subtune)
+}
+
+func (t *Tune) warn(format string, a ...any) {
+	if t.Log != nil {
+		fmt.Fprintf(t.Log, format, a...)
+	}
+}
+
+// Playback plays a subtune frame by frame. Call Next to play a frame,
+// then read the SID state from Sid.
+type Playback struct {
+	Tune        *Tune
+	CPU         *cpu.CPU
+	Sid         *sid.Sid
+	PlayAddress uint16
+
+	// Number of the frame played by the last call to Next
+	Frame int
+
+	// Frames stops once the frames played add up to Length, unless it is
+	// 0
+	Length time.Duration
+
+	frames  int
+	elapsed time.Duration
+	err     error
+
+	// Interpreter of Sidplayer data played without the Sidplayer routine
+	mus *musInterpreter
+}
+
+// Play loads the tune into a new machine, runs the init routine of a
+// subtune (0-based) and returns a playback of the given number of frames.
+func (t *Tune) Play(subtune int, frames int) (*Playback, error) {
+	p := &Playback{Tune: t, CPU: c64.NewCpu(), Sid: sid.NewSID(), PlayAddress: t.Header.PlayAddress, Frame: -1, frames: frames}
+	for _, setup := range t.Setup {
+		setup(p.CPU)
+	}
+	p.CPU.Mem.StoreBytes(t.Header.LoadAddress, t.Data)
+
+	for _, w := range t.warnings {
+		t.warn("%s", w)
+	}
+
+	// Sidplayer data is played by the Sidplayer routine, at the addresses
+	// used by SIDPLAY unless given, or interpreted without it
+	init := t.Header.InitAddress
+	if t.Header.IsMUS() {
+		if t.MUSPlayer == nil {
+			var err error
+			if p.mus, err = newMUSInterpreter(t.Data); err != nil {
+				return nil, err
+			}
+			t.warn("Warning: no Sidplayer routine given, playing the notes of the data without its commands\n")
+			p.mus.init(p.CPU.Mem)
+			c64.ClearSidWrites(p.CPU)
+			return p, nil
+		}
+		p.CPU.Mem.StoreBytes(t.MUSPlayer.Load, t.MUSPlayer.Data)
+		if init == 0 {
+			init = psid.MUS_INIT_ADDRESS
+		}
+		if p.PlayAddress == 0 {
+			p.PlayAddress = psid.MUS_PLAY_ADDRESS
+		}
+		if !t.MUSPlayer.inside(init) || !t.MUSPlayer.inside(p.PlayAddress) {
+			return nil, fmt.Errorf("%w: $%04X/$%04X, routine at $%04X-$%04X", ErrMUSEntry, init, p.PlayAddress,
+				t.MUSPlayer.Load, int(t.MUSPlayer.Load)+len(t.MUSPlayer.Data)-1)
+		}
+	}
+
+	err := c64.CallInit(p.CPU, init, uint8(subtune))
+	switch {
+	case errors.Is(err, c64.ErrInitTimeout):
+		// The tune is played anyway. Without a play address the init
+		// routine may legitimately never return, the tune is then played
+		// from an interrupt.
+		t.warn("Warning: CPU executed a high number of instructions in init, breaking\n")
+	case err != nil:
+		return nil, err
+	}
+
+	if p.PlayAddress == 0 {
+		t.warn("Warning: SID has play address 0, reading from interrupt vector instead\n")
+		p.PlayAddress = c64.IRQVector(p.CPU)
+		t.warn("New play address is $%04X\n", p.PlayAddress)
+	}
+
+	// Only count SID writes made by the playroutine
+	c64.ClearSidWrites(p.CPU)
+	return p, nil
+}
+
+// Next plays the next frame. It returns false when all frames have been
+// played or the play routine failed, see Err.
+func (p *Playback) Next() bool {
+	if p.err != nil || p.Frame+1 >= p.frames {
+		return false
+	}
+	p.Frame++
+
+	var err error
+	if p.mus != nil {
+		p.mus.play(p.CPU.Mem)
+	} else {
+		err = c64.CallPlay(p.CPU, p.PlayAddress, p.Frame)
+	}
+	switch {
+	case errors.Is(err, c64.ErrPlayTimeout):
+		p.Tune.warn("Warning: CPU executed a high number of instructions in play, breaking\n")
+	case err != nil:
+		p.err = err
+		return false
+	}
+
+	// Update Sid with latest values from memory
+	p.Sid.CopyFromCpu(p.CPU)
+	return true
+}
+
+// Cycles returns the number of CPU cycles executed since the tune was
+// loaded
+func (p *Playback) Cycles() uint64 {
+	return p.CPU.Cycles
+}
+
+// Err returns the error that stopped the playback, if any
+func (p *Playback) Err() error {
+	return p.err
+}
@@ -0,0 +1,175 @@
+package psid
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+var ErrBadMUS = errors.New("not a valid Compute's Sidplayer file")
+
+// Compute's Sidplayer data is loaded at MUS_DATA_ADDRESS and played by the
+// Sidplayer routine, which is not part of the file. These are the entry
+// points of the routine as used by SIDPLAY.
+const (
+	MUS_DATA_ADDRESS = 0x0900
+	MUS_INIT_ADDRESS = 0xEC60
+	MUS_PLAY_ADDRESS = 0xEC80
+)
+
+// Command ending the data of a voice
+const MUS_HLT = 0x014F
+
+// Note durations of Sidplayer notes, from the utility duration set by the
+// tune to a sixty-fourth note
+const (
+	MUS_UTILITY = iota
+	MUS_WHOLE
+	MUS_HALF
+	MUS_QUARTER
+	MUS_EIGHTH
+	MUS_SIXTEENTH
+	MUS_THIRTYSECOND
+	MUS_SIXTYFOURTH
+)
+
+// MUSNote is a note or rest of a Sidplayer voice. The first byte holds the
+// duration in bits 2-4, a dot in bit 5, a triplet in bit 6 and a tie to
+// the next note in bit 7; bits 0-1 are 0, other values are commands. The
+// second byte holds the note name C to B as 1-7 in bits 0-2, 0 for a rest,
+// the octave in bits 3-5 and a sharp (1) or flat (2) in bits 6-7.
+type MUSNote struct {
+	Duration int
+	Dotted   bool
+	Triplet  bool
+	Tie      bool
+	Rest     bool
+	// Semitones above C-0
+	Pitch int
+}
+
+// Semitones of the note names C to B above C
+var musSemitones = [8]int{0, 0, 2, 4, 5, 7, 9, 11}
+
+// DecodeMUSNote decodes the two bytes of a voice event, it returns false
+// for commands
+func DecodeMUSNote(b0, b1 byte) (MUSNote, bool) {
+	if b0&0x03 != 0 {
+		return MUSNote{}, false
+	}
+	note := MUSNote{
+		Duration: int(b0>>2) & 7,
+		Dotted:   b0&0x20 != 0,
+		Triplet:  b0&0x40 != 0,
+		Tie:      b0&0x80 != 0,
+		Rest:     b1&7 == 0,
+	}
+	if !note.Rest {
+		note.Pitch = int(b1>>3&7)*12 + musSemitones[b1&7]
+		switch b1 >> 6 {
+		case 1:
+			note.Pitch++
+		case 2:
+			note.Pitch--
+		}
+	}
+	return note, true
+}
+
+// MUS is the data of a Compute's Sidplayer tune: a command stream for each
+// voice, followed by the credits text
+type MUS struct {
+	Voices  [3][]byte
+	Credits []string
+}
+
+// IsMUS reports whether the data of the tune is for Compute's Sidplayer,
+// flag bit 0 of version 2 headers
+func (psid *PSIDHeader) IsMUS() bool {
+	return psid.Version >= 2 && psid.Flags&1 != 0
+}
+
+// ParseMUS splits Sidplayer data, without load address, into the voices
+// and the credits
+func ParseMUS(data []byte) (*MUS, error) {
+	if len(data) < 6 {
+		return nil, ErrTruncated
+	}
+	mus := &MUS{}
+	pos := 6
+	for voice := 0; voice < 3; voice++ {
+		length := int(binary.LittleEndian.Uint16(data[voice*2:]))
+		if length < 2 || pos+length > len(data) {
+			return nil, ErrBadMUS
+		}
+		mus.Voices[voice] = data[pos : pos+length]
+		pos += length
+		if binary.BigEndian.Uint16(data[pos-2:]) != MUS_HLT {
+			return nil, ErrBadMUS
+		}
+	}
+
+	// The credits are PETSCII lines ending at a zero byte
+	var line strings.Builder
+	for _, c := range data[pos:] {
+		if c == 0 {
+			break
+		}
+		switch {
+		case c == 0x0D:
+			mus.Credits = append(mus.Credits, line.String())
+			line.Reset()
+		case c >= 0xC1 && c <= 0xDA:
+			line.WriteByte(c - 0x80)
+		case c >= 0x20 && c < 0x7F:
+			line.WriteByte(c)
+		}
+	}
+	if line.Len() > 0 {
+		mus.Credits = append(mus.Credits, line.String())
+	}
+	return mus, nil
+}
+
+// LoadMUS reads a Compute's Sidplayer .mus file, a load address followed
+// by the data, and returns a header for it. The name, author and released
+// fields come from the first lines of the credits. Stereo .str files are
+// read the same way, only the part for the SID at $D400 is used.
+func LoadMUS(file io.Reader) (*PSIDHeader, []byte, error) {
+	if _, err := readWord(file); err != nil {
+		return nil, nil, err
+	}
+	psid := &PSIDHeader{
+		MagicID:     [4]byte{'P', 'S', 'I', 'D'},
+		Version:     2,
+		DataOffset:  PSID_V2_HEADER_SIZE,
+		LoadAddress: MUS_DATA_ADDRESS,
+		InitAddress: MUS_INIT_ADDRESS,
+		PlayAddress: MUS_PLAY_ADDRESS,
+		Songs:       1,
+		StartSong:   1,
+		Flags:       1,
+	}
+	data, err := psid.LoadPSIDData(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	mus, err := ParseMUS(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fields := []*[32]byte{&psid.Name, &psid.Author, &psid.Released}
+	for i, line := range mus.Credits {
+		if i == len(fields) {
+			break
+		}
+		line = strings.TrimSpace(line)
+		if len(line) > 32 {
+			line = line[:32]
+		}
+		SetText(fields[i], line)
+	}
+	return psid, data, nil
+}
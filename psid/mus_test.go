@@ -0,0 +1,95 @@
+package psid
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testMUS is Sidplayer data without load address: the voice lengths, three
+// voices ending in HLT and the credits in PETSCII, with shifted letters
+// from $C1
+var testMUS = join(
+	[]byte{0x04, 0x00, 0x02, 0x00, 0x02, 0x00},
+	[]byte{0x12, 0x34, 0x01, 0x4F},
+	[]byte{0x01, 0x4F},
+	[]byte{0x01, 0x4F},
+	[]byte("\xd4EST SONG\r  \xc2Y \xd4ESTER  \r1989\r\x00"),
+)
+
+func TestParseMUS(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		err     error
+		credits []string
+	}{
+		{"credits", testMUS, nil, []string{"TEST SONG", "  BY TESTER  ", "1989"}},
+		{"no credits", testMUS[:14], nil, nil},
+		{"truncated lengths", testMUS[:4], ErrTruncated, nil},
+		{"voice past end", testMUS[:12], ErrBadMUS, nil},
+		{"voice without HLT", join([]byte{0x02, 0x00, 0x02, 0x00, 0x02, 0x00}, []byte{0x12, 0x34, 0x01, 0x4F, 0x01, 0x4F}), ErrBadMUS, nil},
+		{"empty voice", join([]byte{0x00, 0x00, 0x02, 0x00, 0x02, 0x00}, []byte{0x01, 0x4F, 0x01, 0x4F}), ErrBadMUS, nil},
+	}
+	for _, test := range tests {
+		mus, err := ParseMUS(test.data)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(mus.Voices[0], []byte{0x12, 0x34, 0x01, 0x4F}) || len(mus.Voices[2]) != 2 {
+			t.Errorf("%s: voices % X", test.name, mus.Voices)
+		}
+		if !reflect.DeepEqual(mus.Credits, test.credits) {
+			t.Errorf("%s: credits %q, want %q", test.name, mus.Credits, test.credits)
+		}
+	}
+}
+
+func TestLoadMUS(t *testing.T) {
+	psid, data, err := LoadMUS(bytes.NewReader(join([]byte{0x00, 0x09}, testMUS)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !psid.IsMUS() || psid.LoadAddress != MUS_DATA_ADDRESS || psid.InitAddress != MUS_INIT_ADDRESS || psid.PlayAddress != MUS_PLAY_ADDRESS {
+		t.Errorf("bad header: %+v", psid)
+	}
+	if !bytes.Equal(data, testMUS) {
+		t.Errorf("data % X, want % X", data, testMUS)
+	}
+	for _, field := range []struct {
+		text *[32]byte
+		want string
+	}{{&psid.Name, "TEST SONG"}, {&psid.Author, "BY TESTER"}, {&psid.Released, "1989"}} {
+		if got := CString(field.text[:]); got != field.want {
+			t.Errorf("got %q, want %q", got, field.want)
+		}
+	}
+}
+
+func TestDecodeMUSNote(t *testing.T) {
+	tests := []struct {
+		name   string
+		b0, b1 byte
+		note   MUSNote
+		ok     bool
+	}{
+		{"quarter C-4", 0x0C, 0x21, MUSNote{Duration: MUS_QUARTER, Pitch: 48}, true},
+		{"dotted half A-2", 0x28, 0x16, MUSNote{Duration: MUS_HALF, Dotted: true, Pitch: 33}, true},
+		{"tied eighth A#4", 0x90, 0x66, MUSNote{Duration: MUS_EIGHTH, Tie: true, Pitch: 58}, true},
+		{"triplet sixteenth Bb-5", 0x54, 0xAF, MUSNote{Duration: MUS_SIXTEENTH, Triplet: true, Pitch: 70}, true},
+		{"utility rest", 0x00, 0x00, MUSNote{Duration: MUS_UTILITY, Rest: true}, true},
+		{"HLT", 0x01, 0x4F, MUSNote{}, false},
+		{"other command", 0x06, 0x12, MUSNote{}, false},
+	}
+	for _, test := range tests {
+		note, ok := DecodeMUSNote(test.b0, test.b1)
+		if ok != test.ok || note != test.note {
+			t.Errorf("%s: got %+v, %v, want %+v, %v", test.name, note, ok, test.note, test.ok)
+		}
+	}
+}
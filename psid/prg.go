@@ -0,0 +1,39 @@
+package psid
+
+import (
+	"io"
+)
+
+// LoadPRG reads a C64 program file, a load address followed by the data,
+// and returns a header for a single song with the given init and play
+// addresses. An init address of 0 starts at the load address.
+func LoadPRG(file io.Reader, init uint16, play uint16) (*PSIDHeader, []byte, error) {
+	load, err := readWord(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return LoadBinary(file, load, init, play)
+}
+
+// LoadBinary reads raw C64 data loaded at the given address and returns a
+// header for a single song, like LoadPRG
+func LoadBinary(file io.Reader, load uint16, init uint16, play uint16) (*PSIDHeader, []byte, error) {
+	if init == 0 {
+		init = load
+	}
+	psid := &PSIDHeader{
+		MagicID:     [4]byte{'P', 'S', 'I', 'D'},
+		Version:     2,
+		DataOffset:  PSID_V2_HEADER_SIZE,
+		LoadAddress: load,
+		InitAddress: init,
+		PlayAddress: play,
+		Songs:       1,
+		StartSong:   1,
+	}
+	data, err := psid.LoadPSIDData(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return psid, data, nil
+}
@@ -0,0 +1,160 @@
+package psid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Errors returned when loading a tune. They may be wrapped, test for them
+// with errors.Is.
+var (
+	ErrTruncated   = errors.New("file is truncated")
+	ErrBadMagic    = errors.New("not a valid psid file")
+	ErrDataTooLong = errors.New("SID data continues past end of C64 memory")
+
+	ErrBadDataOffset = errors.New("data offset points into the header")
+)
+
+type PSIDHeader struct {
+	MagicID     [4]byte
+	Version     uint16
+	DataOffset  uint16
+	LoadAddress uint16
+	InitAddress uint16
+	PlayAddress uint16
+	Songs       uint16
+	StartSong   uint16
+	Speed       uint32
+	Name        [32]byte
+	Author      [32]byte
+	Released    [32]byte
+
+	// Version 2 and later
+	Flags            uint16
+	StartPage        uint8
+	PageLength       uint8
+	SecondSIDAddress uint8
+	ThirdSIDAddress  uint8
+}
+
+// Clock speed, bits 2-3 of Flags
+const (
+	CLOCK_UNKNOWN = 0
+	CLOCK_PAL     = 1
+	CLOCK_NTSC    = 2
+	CLOCK_ANY     = 3
+)
+
+func NewPSID() *PSIDHeader {
+	psid := &PSIDHeader{}
+	return psid
+}
+
+// Clock returns the clock speed the tune was made for
+func (psid *PSIDHeader) Clock() int {
+	return int(psid.Flags>>2) & 3
+}
+
+// SongSpeed returns the speed bit of a song (1-based), 0 for vertical
+// blank interrupt and 1 for CIA timer
+func (psid *PSIDHeader) SongSpeed(song int) int {
+	bit := song - 1
+	if bit > 31 {
+		bit = 31
+	}
+	return int(psid.Speed>>bit) & 1
+}
+
+func (psid *PSIDHeader) PrintPSIDVitals(w io.Writer) {
+	fmt.Fprintf(w, "MagicID:  %s\n", psid.MagicID)
+	fmt.Fprintf(w, "Version:  %X\n", psid.Version)
+	fmt.Fprintf(w, "DataOffset:  0x%X\n", psid.DataOffset)
+	fmt.Fprintf(w, "LoadAddress: 0x%X\n", psid.LoadAddress)
+	fmt.Fprintf(w, "InitAddress: 0x%X\n", psid.InitAddress)
+	fmt.Fprintf(w, "PlayAddress: 0x%X\n", psid.PlayAddress)
+	fmt.Fprintf(w, "Songs: %d\n", psid.Songs)
+	fmt.Fprintf(w, "Startsong: %d\n", psid.StartSong)
+	fmt.Fprintf(w, "Speed: 0x%X\n", psid.Speed)
+	fmt.Fprintf(w, "Name: %s\n", CString(psid.Name[:]))
+	fmt.Fprintf(w, "Author: %s\n", CString(psid.Author[:]))
+	fmt.Fprintf(w, "Copyright: %s\n", CString(psid.Released[:]))
+}
+
+// Header sizes of PSID version 1 and version 2 and later
+const (
+	PSID_V1_HEADER_SIZE = 0x76
+	PSID_V2_HEADER_SIZE = 0x7C
+)
+
+// LoadPSIDHeader reads the header from the start of a PSID file and skips
+// to the data. It reads no further than needed, so the data can be read
+// from the same reader with LoadPSIDData.
+func (psid *PSIDHeader) LoadPSIDHeader(file io.Reader) error {
+	buf := make([]byte, PSID_V2_HEADER_SIZE)
+	n, err := io.ReadFull(file, buf[:PSID_V1_HEADER_SIZE])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return readErr(err)
+	}
+	if n >= 4 && binary.BigEndian.Uint32(buf) != 0x50534944 {
+		return ErrBadMagic
+	}
+	if n < PSID_V1_HEADER_SIZE {
+		return ErrTruncated
+	}
+
+	// Version 2 adds some fields
+	size := PSID_V1_HEADER_SIZE
+	if binary.BigEndian.Uint16(buf[4:]) >= 2 {
+		if _, err := io.ReadFull(file, buf[PSID_V1_HEADER_SIZE:]); err != nil {
+			return readErr(err)
+		}
+		size = PSID_V2_HEADER_SIZE
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, psid); err != nil {
+		return readErr(err)
+	}
+
+	if psid.Version < 2 {
+		psid.Flags = 0
+		psid.StartPage = 0
+		psid.PageLength = 0
+		psid.SecondSIDAddress = 0
+		psid.ThirdSIDAddress = 0
+	}
+
+	if int(psid.DataOffset) < size {
+		return ErrBadDataOffset
+	}
+	if _, err := io.CopyN(io.Discard, file, int64(psid.DataOffset)-int64(size)); err != nil {
+		return readErr(err)
+	}
+	if psid.LoadAddress == 0 {
+		load, err := readWord(file)
+		if err != nil {
+			return err
+		}
+		psid.LoadAddress = load
+	}
+
+	return nil
+}
+
+// LoadPSIDData reads the C64 data following the header, without the load
+// address
+func (psid *PSIDHeader) LoadPSIDData(file io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrTruncated
+	}
+
+	if int(psid.LoadAddress)+len(data) > 0x10000 {
+		return nil, ErrDataTooLong
+	}
+	return data, nil
+}
@@ -0,0 +1,177 @@
+package psid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// header builds a PSID header of the given version, data offset and load
+// address, with the version 2 fields filled in
+func header(version, dataOffset, load uint16) []byte {
+	h := make([]byte, PSID_V2_HEADER_SIZE)
+	copy(h, "PSID")
+	binary.BigEndian.PutUint16(h[0x04:], version)
+	binary.BigEndian.PutUint16(h[0x06:], dataOffset)
+	binary.BigEndian.PutUint16(h[0x08:], load)
+	binary.BigEndian.PutUint16(h[0x0A:], 0x1000)
+	binary.BigEndian.PutUint16(h[0x0C:], 0x1003)
+	binary.BigEndian.PutUint16(h[0x0E:], 3)
+	binary.BigEndian.PutUint16(h[0x10:], 2)
+	binary.BigEndian.PutUint32(h[0x12:], 0x4)
+	copy(h[0x16:], "Name")
+	copy(h[0x36:], "Author")
+	copy(h[0x56:], "Released")
+	binary.BigEndian.PutUint16(h[0x76:], 0x0014)
+	h[0x78] = 0x20
+	h[0x79] = 0x08
+	return h
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestLoadPSIDHeader(t *testing.T) {
+	data := []byte{0x60, 0x60, 0x60}
+	tests := []struct {
+		name  string
+		file  []byte
+		err   error
+		load  uint16
+		flags uint16
+	}{
+		{"embedded load address", join(header(2, 0x7C, 0), []byte{0x00, 0x10}, data), nil, 0x1000, 0x0014},
+		{"load address in header", join(header(2, 0x7C, 0x1000), data), nil, 0x1000, 0x0014},
+		{"version 1", join(header(1, 0x76, 0x1000)[:0x76], data), nil, 0x1000, 0},
+		{"version 1 ignores version 2 fields", join(header(1, 0x7C, 0x1000), data), nil, 0x1000, 0},
+		{"bytes between header and data", join(header(2, 0x80, 0), []byte{1, 2, 3, 4, 0x00, 0x20}, data), nil, 0x2000, 0x0014},
+		{"empty file", nil, ErrTruncated, 0, 0},
+		{"bad magic", join([]byte("RSID"), header(2, 0x7C, 0)[4:]), ErrBadMagic, 0, 0},
+		{"short bad magic", []byte("PS"), ErrTruncated, 0, 0},
+		{"truncated version 1 header", header(1, 0x76, 0x1000)[:0x50], ErrTruncated, 0, 0},
+		{"truncated version 2 header", header(2, 0x7C, 0x1000)[:0x78], ErrTruncated, 0, 0},
+		{"data offset inside header", join(header(2, 0x76, 0x1000), data), ErrBadDataOffset, 0, 0},
+		{"data offset past end", header(2, 0x100, 0x1000), ErrTruncated, 0, 0},
+		{"missing load address", join(header(2, 0x7C, 0), []byte{0x00}), ErrTruncated, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			psid := NewPSID()
+			r := bytes.NewReader(test.file)
+			err := psid.LoadPSIDHeader(r)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if psid.LoadAddress != test.load {
+				t.Errorf("load address $%04X, want $%04X", psid.LoadAddress, test.load)
+			}
+			if psid.Flags != test.flags {
+				t.Errorf("flags $%04X, want $%04X", psid.Flags, test.flags)
+			}
+			if psid.InitAddress != 0x1000 || psid.PlayAddress != 0x1003 || psid.Songs != 3 || psid.StartSong != 2 {
+				t.Errorf("bad addresses or songs: %+v", psid)
+			}
+			if got := CString(psid.Name[:]); got != "Name" {
+				t.Errorf("name %q, want %q", got, "Name")
+			}
+			got, err := psid.LoadPSIDData(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("data % X, want % X", got, data)
+			}
+		})
+	}
+}
+
+func TestLoadPSIDData(t *testing.T) {
+	psid := &PSIDHeader{LoadAddress: 0xFFFE}
+	if _, err := psid.LoadPSIDData(bytes.NewReader([]byte{1, 2})); err != nil {
+		t.Errorf("data up to $FFFF: %v", err)
+	}
+	if _, err := psid.LoadPSIDData(bytes.NewReader([]byte{1, 2, 3})); !errors.Is(err, ErrDataTooLong) {
+		t.Errorf("data past $FFFF: got %v, want %v", err, ErrDataTooLong)
+	}
+	if _, err := psid.LoadPSIDData(bytes.NewReader(nil)); !errors.Is(err, ErrTruncated) {
+		t.Errorf("no data: got %v, want %v", err, ErrTruncated)
+	}
+}
+
+func TestSongSpeed(t *testing.T) {
+	psid := &PSIDHeader{Speed: 0x80000002}
+	for song, want := range map[int]int{1: 0, 2: 1, 3: 0, 32: 1, 40: 1} {
+		if got := psid.SongSpeed(song); got != want {
+			t.Errorf("song %d: speed %d, want %d", song, got, want)
+		}
+	}
+}
+
+func TestWritePSID(t *testing.T) {
+	for _, version := range []uint16{1, 2} {
+		size := uint16(PSID_V2_HEADER_SIZE)
+		if version == 1 {
+			size = PSID_V1_HEADER_SIZE
+		}
+		file := join(header(version, size, 0)[:size], []byte{0x00, 0x10}, []byte{0x60, 0x60, 0x60})
+
+		psid := NewPSID()
+		r := bytes.NewReader(file)
+		if err := psid.LoadPSIDHeader(r); err != nil {
+			t.Fatal(err)
+		}
+		data, err := psid.LoadPSIDData(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := psid.WritePSID(&buf, data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), file) {
+			t.Errorf("version %d: written file differs\n got % X\nwant % X", version, buf.Bytes(), file)
+		}
+	}
+
+	psid := &PSIDHeader{Version: 2, LoadAddress: 0x1000, Songs: 2, StartSong: 3}
+	if err := psid.WritePSID(&bytes.Buffer{}, []byte{0x60}); !errors.Is(err, ErrBadSongs) {
+		t.Errorf("start song past songs: got %v, want %v", err, ErrBadSongs)
+	}
+}
+
+func TestLoadPRG(t *testing.T) {
+	psid, data, err := LoadPRG(bytes.NewReader([]byte{0x01, 0x08, 0x60, 0x60}), 0, 0x0803)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if psid.LoadAddress != 0x0801 || psid.InitAddress != 0x0801 || psid.PlayAddress != 0x0803 {
+		t.Errorf("addresses $%04X $%04X $%04X, want $0801 $0801 $0803", psid.LoadAddress, psid.InitAddress, psid.PlayAddress)
+	}
+	if !bytes.Equal(data, []byte{0x60, 0x60}) {
+		t.Errorf("data % X, want 60 60", data)
+	}
+	if _, _, err := LoadPRG(bytes.NewReader([]byte{0x01}), 0, 0); !errors.Is(err, ErrTruncated) {
+		t.Errorf("one byte file: got %v, want %v", err, ErrTruncated)
+	}
+}
+
+func TestPrintPSIDVitals(t *testing.T) {
+	psid := NewPSID()
+	if err := psid.LoadPSIDHeader(bytes.NewReader(join(header(2, 0x7C, 0x1000), []byte{0x60}))); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	psid.PrintPSIDVitals(&buf)
+	if bytes.IndexByte(buf.Bytes(), 0) >= 0 {
+		t.Errorf("NUL bytes printed: %q", buf.String())
+	}
+	want := "Name: Name\nAuthor: Author\nCopyright: Released\n"
+	if !bytes.HasSuffix(buf.Bytes(), []byte(want)) {
+		t.Errorf("got %q, want it to end with %q", buf.String(), want)
+	}
+}
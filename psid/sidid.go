@@ -0,0 +1,169 @@
+package psid
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Wildcard byte in a signature
+const SIG_ANY = -1
+
+// PlayerSignature is one signature of a player. Each part is a byte
+// sequence that must be found after the end of the previous one.
+type PlayerSignature struct {
+	Player string
+	Parts  [][]int
+}
+
+// PlayerIds is a database of music player signatures, read from a
+// configuration file in SIDId format: a line with the player name is
+// followed by signatures of hex bytes, where ?? matches any byte, AND
+// separates parts that may be apart and END terminates the signature.
+type PlayerIds struct {
+	signatures []PlayerSignature
+}
+
+func LoadPlayerIds(fileName string) (*PlayerIds, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ids := &PlayerIds{}
+	player := ""
+	var sig *PlayerSignature
+
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		tokens := strings.Fields(scanner.Text())
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if !isSignatureLine(tokens) {
+			player = strings.TrimSpace(scanner.Text())
+			continue
+		}
+		if player == "" {
+			return nil, fmt.Errorf("%s:%d: signature without player name", fileName, lineNum)
+		}
+
+		for _, t := range tokens {
+			if sig == nil {
+				sig = &PlayerSignature{Player: player, Parts: [][]int{nil}}
+			}
+			part := len(sig.Parts) - 1
+
+			switch t {
+			case "END":
+				ids.signatures = append(ids.signatures, *sig)
+				sig = nil
+			case "AND":
+				sig.Parts = append(sig.Parts, nil)
+			case "??":
+				sig.Parts[part] = append(sig.Parts[part], SIG_ANY)
+			default:
+				b, err := strconv.ParseUint(t, 16, 8)
+				if err != nil || len(t) != 2 {
+					return nil, fmt.Errorf("%s:%d: bad signature byte %s", fileName, lineNum, t)
+				}
+				sig.Parts[part] = append(sig.Parts[part], int(b))
+			}
+		}
+	}
+	if sig != nil {
+		return nil, errors.New(fileName + ": signature of " + sig.Player + " not terminated with END")
+	}
+
+	return ids, scanner.Err()
+}
+
+// isSignatureLine reports whether a line holds signature bytes and
+// keywords only, any other line is a player name
+func isSignatureLine(tokens []string) bool {
+	for _, t := range tokens {
+		if t == "??" || t == "AND" || t == "END" {
+			continue
+		}
+		if _, err := strconv.ParseUint(t, 16, 8); err != nil || len(t) != 2 {
+			return false
+		}
+	}
+	return true
+}
+
+// findPart returns the position just after the first match of a part at
+// or after start, or -1 if the part is not found
+func findPart(data []byte, part []int, start int) int {
+	for pos := start; pos+len(part) <= len(data); pos++ {
+		match := true
+		for i, b := range part {
+			if b != SIG_ANY && int(data[pos+i]) != b {
+				match = false
+				break
+			}
+		}
+		if match {
+			return pos + len(part)
+		}
+	}
+	return -1
+}
+
+// Matches reports whether all parts of the signature are found in order
+func (sig *PlayerSignature) Matches(data []byte) bool {
+	pos := 0
+	for _, part := range sig.Parts {
+		if pos = findPart(data, part, pos); pos < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Identify returns the names of all players with a signature matching
+// the tune data
+func (ids *PlayerIds) Identify(data []byte) []string {
+	var players []string
+	for i := range ids.signatures {
+		sig := &ids.signatures[i]
+		if len(players) > 0 && players[len(players)-1] == sig.Player {
+			continue
+		}
+		if sig.Matches(data) {
+			players = append(players, sig.Player)
+		}
+	}
+	return players
+}
+
+// PlayerVersion splits a SIDId player name like GoatTracker_V2.x into
+// the player and its version
+func PlayerVersion(name string) (string, string) {
+	if i := strings.LastIndex(name, "_V"); i > 0 {
+		return name[:i], name[i+2:]
+	}
+	return name, ""
+}
+
+// PrintPlayers prints the identified players, as shown with the header
+func PrintPlayers(w io.Writer, players []string) {
+	if len(players) == 0 {
+		fmt.Fprintln(w, "Player: unidentified")
+		return
+	}
+	for _, p := range players {
+		name, version := PlayerVersion(p)
+		if version != "" {
+			fmt.Fprintf(w, "Player: %s (version %s)\n", name, version)
+		} else {
+			fmt.Fprintf(w, "Player: %s\n", name)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package psid
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSIDId = `GoatTracker_V2.x
+A9 ?? 8D 18 D4 END
+Hubbard
+20 ?? ?? AND 8D 04 D4 END
+4C ?? 10 END
+`
+
+func TestPlayerIds(t *testing.T) {
+	ids, err := LoadPlayerIds(writeFile(t, "sidid.cfg", testSIDId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		data    []byte
+		players []string
+	}{
+		{"wildcard", []byte{0x00, 0xA9, 0x0F, 0x8D, 0x18, 0xD4}, []string{"GoatTracker_V2.x"}},
+		{"parts apart", []byte{0x20, 0x00, 0x10, 0xEA, 0xEA, 0x8D, 0x04, 0xD4}, []string{"Hubbard"}},
+		{"parts in the wrong order", []byte{0x8D, 0x04, 0xD4, 0x20, 0x00, 0x10}, nil},
+		{"second signature", []byte{0x4C, 0x00, 0x10}, []string{"Hubbard"}},
+		{"both players", []byte{0xA9, 0x0F, 0x8D, 0x18, 0xD4, 0x4C, 0x00, 0x10}, []string{"GoatTracker_V2.x", "Hubbard"}},
+		{"no match", []byte{0xA9, 0x0F, 0x8D, 0x18}, nil},
+	}
+	for _, test := range tests {
+		if got := ids.Identify(test.data); !reflect.DeepEqual(got, test.players) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.players)
+		}
+	}
+}
+
+func TestLoadPlayerIdsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"no player name", "A9 00 END\n"},
+		{"no END", "Player\nA9 00\n"},
+	}
+	for _, test := range tests {
+		if _, err := LoadPlayerIds(writeFile(t, "sidid.cfg", test.text)); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestPrintPlayers(t *testing.T) {
+	var sb strings.Builder
+	PrintPlayers(&sb, []string{"GoatTracker_V2.x", "Hubbard"})
+	PrintPlayers(&sb, nil)
+	want := "Player: GoatTracker (version 2.x)\nPlayer: Hubbard\nPlayer: unidentified\n"
+	if sb.String() != want {
+		t.Errorf("got %q, want %q", sb.String(), want)
+	}
+}
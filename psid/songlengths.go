@@ -0,0 +1,131 @@
+package psid
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SongLengths is an HVSC song length database, mapping tune MD5
+// fingerprints to the length of each subtune in seconds.
+type SongLengths struct {
+	lengths map[string][]float64
+}
+
+// LoadSongLengths reads a Songlengths.md5 (or older Songlengths.txt) file
+func LoadSongLengths(fileName string) (*SongLengths, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	db := &SongLengths{lengths: make(map[string][]float64)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '[' {
+			continue
+		}
+
+		md5sum, times, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+
+		var lengths []float64
+		for _, t := range strings.Fields(times) {
+			seconds, err := parseSongLength(t)
+			if err != nil {
+				return nil, errors.New("bad song length " + t + " in " + fileName)
+			}
+			lengths = append(lengths, seconds)
+		}
+		db.lengths[strings.ToLower(strings.TrimSpace(md5sum))] = lengths
+	}
+
+	return db, scanner.Err()
+}
+
+// parseSongLength parses a time in m:ss or m:ss.sss format, optionally
+// followed by attributes in parentheses
+func parseSongLength(t string) (float64, error) {
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = t[:i]
+	}
+
+	min, sec, found := strings.Cut(t, ":")
+	if !found {
+		return 0, errors.New("missing ':'")
+	}
+	m, err := strconv.Atoi(min)
+	if err != nil {
+		return 0, err
+	}
+	s, err := strconv.ParseFloat(sec, 64)
+	if err != nil {
+		return 0, err
+	}
+	return float64(m)*60 + s, nil
+}
+
+// Lookup returns the length in seconds of a subtune (0-based) of the tune
+// with the given MD5 fingerprint
+func (db *SongLengths) Lookup(md5sum string, subtune int) (float64, bool) {
+	lengths, ok := db.lengths[md5sum]
+	if !ok || subtune < 0 || subtune >= len(lengths) {
+		return 0, false
+	}
+	return lengths[subtune], true
+}
+
+// NewMD5 returns the fingerprint used by Songlengths.md5 since HVSC 68,
+// the MD5 of the whole file.
+func NewMD5(file []byte) string {
+	sum := md5.Sum(file)
+	return hex.EncodeToString(sum[:])
+}
+
+// OldMD5 returns the fingerprint used by Songlengths.txt up to HVSC 67,
+// computed the way sidplay2 does it: the C64 data without load address,
+// init and play address, number of songs, the speed of each song and the
+// clock speed if NTSC.
+func (psid *PSIDHeader) OldMD5(data []byte) string {
+	h := md5.New()
+	h.Write(data)
+
+	var tmp [2]byte
+	for _, v := range []uint16{psid.InitAddress, psid.PlayAddress, psid.Songs} {
+		binary.LittleEndian.PutUint16(tmp[:], v)
+		h.Write(tmp[:])
+	}
+
+	for song := 1; song <= int(psid.Songs); song++ {
+		// sidplay2 speed values, 0 for VBI and 60 for CIA
+		speed := byte(0)
+		if psid.SongSpeed(song) != 0 {
+			speed = 60
+		}
+		h.Write([]byte{speed})
+	}
+
+	if psid.Clock() == CLOCK_NTSC {
+		h.Write([]byte{CLOCK_NTSC})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// SongLength looks up the length of a subtune in the database, trying
+// the new fingerprint of the whole file first
+func (psid *PSIDHeader) SongLength(db *SongLengths, file []byte, data []byte, subtune int) (float64, bool) {
+	if length, ok := db.Lookup(NewMD5(file), subtune); ok {
+		return length, true
+	}
+	return db.Lookup(psid.OldMD5(data), subtune)
+}
@@ -0,0 +1,104 @@
+package psid
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFile writes a database file to a temporary directory and returns
+// its name
+func writeFile(t *testing.T, name string, text string) string {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(fileName, []byte(text), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestParseSongLength(t *testing.T) {
+	tests := []struct {
+		text    string
+		seconds float64
+		ok      bool
+	}{
+		{"1:02", 62, true},
+		{"0:05.500", 5.5, true},
+		{"3:00(G)", 180, true},
+		{"10:00.25(M)(Z)", 600.25, true},
+		{"62", 0, false},
+		{"a:00", 0, false},
+		{"1:xx", 0, false},
+	}
+	for _, test := range tests {
+		seconds, err := parseSongLength(test.text)
+		if (err == nil) != test.ok || seconds != test.seconds {
+			t.Errorf("%q: got %v, %v, want %v", test.text, seconds, err, test.seconds)
+		}
+	}
+}
+
+func TestOldMD5(t *testing.T) {
+	data := []byte{0x60, 0x60, 0x60}
+	tests := []struct {
+		name  string
+		flags uint16
+		md5   string
+	}{
+		// Data, init, play, songs, speeds of the three songs
+		{"PAL", CLOCK_PAL << 2, "0c716ab761de7c6d28718b1e29bd553d"},
+		// Followed by the NTSC clock
+		{"NTSC", CLOCK_NTSC << 2, "817b6114d214810da7ae155336753821"},
+	}
+	for _, test := range tests {
+		psid := &PSIDHeader{Version: 2, InitAddress: 0x1000, PlayAddress: 0x1003, Songs: 3, Speed: 0x4, Flags: test.flags}
+		if got := psid.OldMD5(data); got != test.md5 {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.md5)
+		}
+	}
+}
+
+func TestSongLengths(t *testing.T) {
+	file := join(header(2, 0x7C, 0), []byte{0x00, 0x10, 0x60, 0x60, 0x60})
+	psid := NewPSID()
+	r := bytes.NewReader(file)
+	if err := psid.LoadPSIDHeader(r); err != nil {
+		t.Fatal(err)
+	}
+	data, err := psid.LoadPSIDData(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDB := "; Songlengths.md5\n[Database]\n; /MUSICIANS/T/Test.sid\n" + NewMD5(file) + "=1:00 0:30.5(G) 2:00\n"
+	oldDB := "[Database]\n" + psid.OldMD5(data) + "=0:10\n"
+	tests := []struct {
+		name    string
+		db      string
+		subtune int
+		seconds float64
+		ok      bool
+	}{
+		{"new MD5", newDB, 0, 60, true},
+		{"new MD5 with attribute", newDB, 1, 30.5, true},
+		{"subtune past the list", newDB, 3, 0, false},
+		{"old MD5", oldDB, 0, 10, true},
+		{"not in database", "[Database]\n0123456789abcdef0123456789abcdef=1:00\n", 0, 0, false},
+	}
+	for _, test := range tests {
+		db, err := LoadSongLengths(writeFile(t, "Songlengths.md5", test.db))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		seconds, ok := psid.SongLength(db, file, data, test.subtune)
+		if ok != test.ok || seconds != test.seconds {
+			t.Errorf("%s: got %v, %v, want %v, %v", test.name, seconds, ok, test.seconds, test.ok)
+		}
+	}
+
+	if _, err := LoadSongLengths(writeFile(t, "bad.md5", "0123=1:00 x\n")); err == nil {
+		t.Error("bad song length accepted")
+	}
+}
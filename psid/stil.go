@@ -0,0 +1,131 @@
+package psid
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// STILField is one field of an STIL entry, e.g. TITLE or COMMENT
+type STILField struct {
+	Name  string
+	Value string
+}
+
+// STILEntry holds the STIL information of one tune. Fields for the
+// whole file are in subtune 0.
+type STILEntry struct {
+	Path     string
+	Subtunes map[int][]STILField
+}
+
+// STIL is the HVSC SID Tune Information List, indexed by HVSC path
+type STIL struct {
+	entries map[string]*STILEntry
+}
+
+var stilFields = []string{"NAME", "AUTHOR", "TITLE", "ARTIST", "COMMENT"}
+
+// LoadSTIL reads an STIL.txt file
+func LoadSTIL(fileName string) (*STIL, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stil := &STIL{entries: make(map[string]*STILEntry)}
+
+	var entry *STILEntry
+	var field *STILField
+	subtune := 0
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "":
+			entry = nil
+			field = nil
+		case line[0] == '#':
+			// Comment
+		case line[0] == '/':
+			entry = &STILEntry{Path: line, Subtunes: make(map[int][]STILField)}
+			stil.entries[line] = entry
+			field = nil
+			subtune = 0
+		case entry == nil:
+			// Text outside of an entry
+		case strings.HasPrefix(trimmed, "(#") && strings.HasSuffix(trimmed, ")"):
+			n, err := strconv.Atoi(trimmed[2 : len(trimmed)-1])
+			if err == nil {
+				subtune = n
+			}
+			field = nil
+		default:
+			name, value, ok := parseSTILField(trimmed)
+			if ok {
+				fields := append(entry.Subtunes[subtune], STILField{name, value})
+				entry.Subtunes[subtune] = fields
+				field = &fields[len(fields)-1]
+			} else if field != nil {
+				// Continuation line
+				field.Value += " " + trimmed
+			}
+		}
+	}
+
+	return stil, scanner.Err()
+}
+
+func parseSTILField(line string) (string, string, bool) {
+	for _, name := range stilFields {
+		if strings.HasPrefix(line, name+":") {
+			return name, strings.TrimSpace(line[len(name)+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// Lookup finds the entry of a tune file. The file is matched by the
+// longest HVSC path that is a suffix of its absolute path, so tunes are
+// found wherever the HVSC tree is placed.
+func (stil *STIL) Lookup(fileName string) *STILEntry {
+	path, err := filepath.Abs(fileName)
+	if err != nil {
+		path = fileName
+	}
+	path = filepath.ToSlash(path)
+
+	for i := 0; i < len(path); i++ {
+		if path[i] != '/' {
+			continue
+		}
+		if entry, ok := stil.entries[path[i:]]; ok {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Fields returns the fields that apply to a song (1-based): the ones for
+// the whole file followed by the ones for the song.
+func (entry *STILEntry) Fields(song int) []STILField {
+	var fields []STILField
+	fields = append(fields, entry.Subtunes[0]...)
+	fields = append(fields, entry.Subtunes[song]...)
+	return fields
+}
+
+func (entry *STILEntry) PrintSTIL(w io.Writer, song int) {
+	fmt.Fprintf(w, "STIL: %s\n", entry.Path)
+	for _, f := range entry.Fields(song) {
+		fmt.Fprintf(w, "  %s: %s\n", f.Name, f.Value)
+	}
+}
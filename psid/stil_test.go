@@ -0,0 +1,69 @@
+package psid
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testSTIL = `### STIL test ###
+# Comment
+
+/MUSICIANS/T/Tester/Song.sid
+   TITLE: Song
+  ARTIST: Somebody
+ COMMENT: A comment that goes on
+          over two lines
+(#2)
+   TITLE: Second song
+
+/MUSICIANS/T/Tester/Other.sid
+  AUTHOR: Tester
+`
+
+func TestSTIL(t *testing.T) {
+	stil, err := LoadSTIL(writeFile(t, "STIL.txt", testSTIL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		file   string
+		song   int
+		fields []STILField
+	}{
+		{"whole file", "/hvsc/MUSICIANS/T/Tester/Song.sid", 1, []STILField{
+			{"TITLE", "Song"}, {"ARTIST", "Somebody"}, {"COMMENT", "A comment that goes on over two lines"},
+		}},
+		{"subtune", "/hvsc/MUSICIANS/T/Tester/Song.sid", 2, []STILField{
+			{"TITLE", "Song"}, {"ARTIST", "Somebody"}, {"COMMENT", "A comment that goes on over two lines"},
+			{"TITLE", "Second song"},
+		}},
+		{"HVSC at the root", "/MUSICIANS/T/Tester/Other.sid", 1, []STILField{{"AUTHOR", "Tester"}}},
+		{"not in STIL", "/hvsc/MUSICIANS/T/Tester/Missing.sid", 1, nil},
+		{"partial directory name", "/hvsc/XMUSICIANS/T/Tester/Song.sid", 1, nil},
+	}
+	for _, test := range tests {
+		entry := stil.Lookup(filepath.FromSlash(test.file))
+		if entry == nil {
+			if test.fields != nil {
+				t.Errorf("%s: no entry", test.name)
+			}
+			continue
+		}
+		if test.fields == nil {
+			t.Errorf("%s: found %s", test.name, entry.Path)
+			continue
+		}
+		if got := entry.Fields(test.song); !reflect.DeepEqual(got, test.fields) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.fields)
+		}
+	}
+
+	var sb strings.Builder
+	stil.Lookup("/MUSICIANS/T/Tester/Other.sid").PrintSTIL(&sb, 1)
+	if want := "STIL: /MUSICIANS/T/Tester/Other.sid\n  AUTHOR: Tester\n"; sb.String() != want {
+		t.Errorf("printed %q, want %q", sb.String(), want)
+	}
+}
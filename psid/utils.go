@@ -0,0 +1,32 @@
+package psid
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// readWord reads a little-endian word, such as a C64 load address
+func readWord(f io.Reader) (uint16, error) {
+	var res uint16
+	err := binary.Read(f, binary.LittleEndian, &res)
+	return res, readErr(err)
+}
+
+// readErr reports a file that ends before a read is complete as truncated
+func readErr(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrTruncated
+	}
+	return err
+}
+
+// CString returns the text of a zero padded string field
+func CString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
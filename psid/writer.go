@@ -0,0 +1,84 @@
+package psid
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrBadSongs   = errors.New("start song must be between 1 and the number of songs")
+	ErrTextLength = errors.New("text is longer than 32 characters")
+)
+
+// SID model, bits 4-5 of Flags
+const (
+	MODEL_UNKNOWN = 0
+	MODEL_6581    = 1
+	MODEL_8580    = 2
+	MODEL_ANY     = 3
+)
+
+// SIDModel returns the SID model the tune was made for
+func (psid *PSIDHeader) SIDModel() int {
+	return int(psid.Flags>>4) & 3
+}
+
+// SetClock sets the clock speed, upgrading the header to version 2
+func (psid *PSIDHeader) SetClock(clock int) {
+	psid.upgrade()
+	psid.Flags = psid.Flags&^(3<<2) | uint16(clock&3)<<2
+}
+
+// SetSIDModel sets the SID model, upgrading the header to version 2
+func (psid *PSIDHeader) SetSIDModel(model int) {
+	psid.upgrade()
+	psid.Flags = psid.Flags&^(3<<4) | uint16(model&3)<<4
+}
+
+// upgrade turns a version 1 header into version 2, which has the flags
+func (psid *PSIDHeader) upgrade() {
+	if psid.Version < 2 {
+		psid.Version = 2
+	}
+}
+
+// SetText stores text in a zero padded string field such as Name
+func SetText(field *[32]byte, text string) error {
+	if len(text) > len(field) {
+		return ErrTextLength
+	}
+	*field = [32]byte{}
+	copy(field[:], text)
+	return nil
+}
+
+// WritePSID writes the header and the C64 data as a PSID file. Version 1
+// headers are written without the version 2 fields. Like in HVSC the load
+// address is written in front of the data, as in a C64 program file.
+func (psid *PSIDHeader) WritePSID(w io.Writer, data []byte) error {
+	if psid.Songs < 1 || psid.StartSong < 1 || psid.StartSong > psid.Songs {
+		return ErrBadSongs
+	}
+	if int(psid.LoadAddress)+len(data) > 0x10000 {
+		return ErrDataTooLong
+	}
+
+	header := *psid
+	size := PSID_V2_HEADER_SIZE
+	if header.Version < 2 {
+		size = PSID_V1_HEADER_SIZE
+	}
+	header.DataOffset = uint16(size)
+	header.LoadAddress = 0
+
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.BigEndian, &header); err != nil {
+		return err
+	}
+	file := append(buf.Bytes()[:size], byte(psid.LoadAddress), byte(psid.LoadAddress>>8))
+	file = append(file, data...)
+	_, err := w.Write(file)
+	return err
+}
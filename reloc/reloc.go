@@ -0,0 +1,345 @@
+package reloc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"siddump/c64"
+	"siddump/player"
+
+	"github.com/beevik/go6502/cpu"
+)
+
+var (
+	ErrNotPageAligned = errors.New("tunes can only be moved by whole pages")
+	ErrBadAddress     = errors.New("relocated tune would overlap zero page, stack or I/O area")
+)
+
+// Result is a relocated tune
+type Result struct {
+	// The new .sid file
+	File []byte
+	// Number of address bytes changed
+	Relocated int
+	// Instructions using a tune address whose high byte was computed, so
+	// it could not be relocated
+	Unrelocatable []uint16
+	// Tune bytes never executed, read or written while playing. Addresses
+	// in them were copied unchanged.
+	Unreached []Range
+}
+
+// Range is a range of addresses of the original tune, both inclusive
+type Range struct {
+	First, Last uint16
+}
+
+func (r Range) String() string {
+	if r.First == r.Last {
+		return fmt.Sprintf("$%04X", r.First)
+	}
+	return fmt.Sprintf("$%04X-$%04X", r.First, r.Last)
+}
+
+// Relocate moves a tune to a new load address. All subtunes are played for
+// the given number of frames to find the bytes holding the high byte of an
+// address inside the tune: operands of instructions, pointers and address
+// tables. Only bytes used while playing are found, so the result should be
+// checked with Verify.
+func Relocate(t *player.Tune, address uint16, frames int) (*Result, error) {
+	if (address-t.Header.LoadAddress)&0xFF != 0 {
+		return nil, ErrNotPageAligned
+	}
+	shift := uint8((address - t.Header.LoadAddress) >> 8)
+	end := int(t.Header.LoadAddress) + len(t.Data) - 1
+	newEnd := int(address) + len(t.Data) - 1
+	if address < 0x200 || newEnd > 0xFFFF || (newEnd >= 0xD000 && address < 0xE000) {
+		return nil, ErrBadAddress
+	}
+
+	tr := &tracker{
+		tune:     t,
+		first:    t.Header.LoadAddress &^ 0xFF,
+		last:     uint16(end) | 0xFF,
+		high:     make(map[uint16]bool),
+		computed: make(map[uint16]bool),
+		reached:  make([]bool, len(t.Data)),
+	}
+	tune := *t
+	tune.Setup = []func(c *cpu.CPU){tr.attach}
+	for subtune := 0; subtune < int(t.Header.Songs) || subtune == 0; subtune++ {
+		playback, err := tune.Play(subtune, frames)
+		if err != nil {
+			return nil, err
+		}
+		if t.Header.PlayAddress == 0 {
+			// The play routine is found through the interrupt vector
+			vector := uint16(0x315)
+			if playback.CPU.Mem.LoadByte(0x01)&0x07 == 0x5 {
+				vector = 0xFFFF
+			}
+			tr.mark(vector, playback.CPU.Reg.PC, true)
+		}
+		// The instruction a routine returns with is not stepped
+		tr.reach(playback.CPU.Reg.PC)
+		for playback.Next() {
+			tr.reach(playback.CPU.Reg.PC)
+		}
+		if err := playback.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Patch the file: the addresses in the header and the data
+	res := &Result{File: append([]byte(nil), t.File...)}
+	file := res.File
+	dataStart := len(file) - len(t.Data)
+	if binary.BigEndian.Uint16(file[0x08:]) == 0 {
+		binary.LittleEndian.PutUint16(file[dataStart-2:], address)
+	} else {
+		binary.BigEndian.PutUint16(file[0x08:], address)
+	}
+	for _, offset := range []int{0x0A, 0x0C} {
+		if addr := binary.BigEndian.Uint16(file[offset:]); tr.inside(addr) {
+			binary.BigEndian.PutUint16(file[offset:], addr+uint16(shift)<<8)
+		}
+	}
+	if t.Header.Version >= 2 && t.Header.StartPage != 0 && t.Header.StartPage != 0xFF {
+		// Forget the free pages for the player when the tune moved there
+		first := int(t.Header.StartPage)
+		last := first + int(t.Header.PageLength) - 1
+		if first <= newEnd>>8 && last >= int(address>>8) {
+			file[0x78] = 0
+			file[0x79] = 0
+		}
+	}
+	for addr := range tr.high {
+		file[dataStart+int(addr-t.Header.LoadAddress)] += shift
+		res.Relocated++
+	}
+	for pc := range tr.computed {
+		res.Unrelocatable = append(res.Unrelocatable, pc)
+	}
+	sort.Slice(res.Unrelocatable, func(i, j int) bool { return res.Unrelocatable[i] < res.Unrelocatable[j] })
+	for i := 0; i < len(tr.reached); i++ {
+		if tr.reached[i] {
+			continue
+		}
+		first := i
+		for i+1 < len(tr.reached) && !tr.reached[i+1] {
+			i++
+		}
+		load := t.Header.LoadAddress
+		res.Unreached = append(res.Unreached, Range{load + uint16(first), load + uint16(i)})
+	}
+	return res, nil
+}
+
+// No origin: the value was not loaded from the tune data
+const NONE = -1
+
+// tracker follows where the values in memory and in the registers were
+// loaded from, to find the tune bytes an address was built from
+type tracker struct {
+	tune        *player.Tune
+	first, last uint16
+
+	// Tune address each byte of memory and each register was loaded from
+	origin  [0x10000]int32
+	a, x, y int32
+	// Origin of the value the current instruction stores
+	store int32
+
+	// Tune bytes holding the high byte of an address, and instructions
+	// using an address that was computed
+	high     map[uint16]bool
+	computed map[uint16]bool
+	// Tune bytes accessed in any way, by offset in the data
+	reached []bool
+}
+
+// attach installs the tracker on the machine of a new playback
+func (tr *tracker) attach(c *cpu.CPU) {
+	load := int(tr.tune.Header.LoadAddress)
+	for addr := range tr.origin {
+		tr.origin[addr] = NONE
+		if addr >= load && addr < load+len(tr.tune.Data) {
+			tr.origin[addr] = int32(addr)
+		}
+	}
+	tr.a, tr.x, tr.y = NONE, NONE, NONE
+	c64.OnStep(c, tr.step)
+	c64.OnStore(c, tr.storeHook)
+}
+
+// reach records an access of addr
+func (tr *tracker) reach(addr uint16) {
+	if offset := int(addr) - int(tr.tune.Header.LoadAddress); offset >= 0 && offset < len(tr.reached) {
+		tr.reached[offset] = true
+	}
+}
+
+// highByte reports whether the tune byte at origin could be the high byte of
+// an address inside the tune
+func (tr *tracker) highByte(origin int32) bool {
+	v := uint16(tr.tune.Data[uint16(origin)-tr.tune.Header.LoadAddress])
+	return v >= tr.first>>8 && v <= tr.last>>8
+}
+
+func (tr *tracker) inside(addr uint16) bool {
+	return addr >= tr.first && addr <= tr.last
+}
+
+// mark records that the byte at addr is the high byte of a tune address.
+// Return addresses pushed by JSR need no relocation, report is false.
+func (tr *tracker) mark(addr uint16, pc uint16, report bool) {
+	if o := tr.origin[addr]; o != NONE {
+		tr.high[uint16(o)] = true
+	} else if report {
+		tr.computed[pc] = true
+	}
+}
+
+func (tr *tracker) step(c *cpu.CPU, routine string, frame int) error {
+	pc := c.Reg.PC
+	inst := c.InstSet.Lookup(c.Mem.LoadByte(pc))
+	addr, ptr, hasOperand := c64.Operand(c)
+	sp := c.Reg.SP
+
+	for i := uint16(0); i < uint16(inst.Length); i++ {
+		tr.reach(pc + i)
+	}
+	for _, read := range c64.Reads(c) {
+		tr.reach(read)
+	}
+
+	// Addresses used by the instruction
+	switch inst.Mode {
+	case cpu.ABS, cpu.ABX, cpu.ABY, cpu.IND:
+		base := uint16(c.Mem.LoadByte(pc+1)) | uint16(c.Mem.LoadByte(pc+2))<<8
+		if tr.inside(base) || (inst.Mode != cpu.IND && tr.inside(addr)) {
+			tr.mark(pc+2, pc, true)
+		}
+	}
+	switch inst.Mode {
+	case cpu.IND, cpu.IDX, cpu.IDY:
+		if tr.inside(c.Mem.LoadAddress(ptr)) || tr.inside(addr) {
+			tr.mark(c64.PointerHigh(ptr), pc, true)
+		}
+	}
+	switch inst.Name {
+	case "RTS":
+		ret := uint16(c.Mem.LoadByte(0x100+uint16(sp+1))) | uint16(c.Mem.LoadByte(0x100+uint16(sp+2)))<<8
+		if tr.inside(ret + 1) {
+			tr.mark(0x100+uint16(sp+2), pc, false)
+		}
+	case "RTI":
+		ret := uint16(c.Mem.LoadByte(0x100+uint16(sp+2))) | uint16(c.Mem.LoadByte(0x100+uint16(sp+3)))<<8
+		if tr.inside(ret) {
+			tr.mark(0x100+uint16(sp+3), pc, false)
+		}
+	}
+
+	// Where the value loaded by the instruction comes from
+	src := int32(NONE)
+	switch {
+	case inst.Mode == cpu.IMM:
+		src = tr.origin[pc+1]
+	case hasOperand:
+		src = tr.origin[addr]
+	}
+
+	// Follow the values through the registers
+	tr.store = NONE
+	switch inst.Name {
+	case "LDA":
+		tr.a = src
+	case "LDX":
+		tr.x = src
+	case "LDY":
+		tr.y = src
+	case "TAX":
+		tr.x = tr.a
+	case "TAY":
+		tr.y = tr.a
+	case "TXA":
+		tr.a = tr.x
+	case "TYA":
+		tr.a = tr.y
+	case "TSX":
+		tr.x = NONE
+	case "PLA":
+		tr.a = tr.origin[0x100+uint16(sp+1)]
+	case "STA", "PHA":
+		tr.store = tr.a
+	case "STX":
+		tr.store = tr.x
+	case "STY":
+		tr.store = tr.y
+	case "INC", "DEC":
+		tr.store = src
+	case "ADC", "SBC":
+		// Adding an offset to an address keeps its origin. When both
+		// values come from the tune, the one that can be the high byte of
+		// a tune address is taken, if that is unclear the result is
+		// computed.
+		switch {
+		case tr.a == NONE:
+			tr.a = src
+		case src == NONE:
+		case tr.highByte(tr.a) == tr.highByte(src):
+			tr.a = NONE
+		case tr.highByte(src):
+			tr.a = src
+		}
+	case "AND", "ORA", "EOR":
+		tr.a = NONE
+	case "ASL", "LSR", "ROL", "ROR":
+		if inst.Mode == cpu.ACC {
+			tr.a = NONE
+		}
+	}
+	return nil
+}
+
+func (tr *tracker) storeHook(addr uint16, v byte) {
+	tr.reach(addr)
+	tr.origin[addr] = tr.store
+}
+
+// Difference is the first SID register that differs between two tunes
+type Difference struct {
+	Frame    int
+	Register int
+	A, B     uint8
+}
+
+func (d *Difference) String() string {
+	return fmt.Sprintf("frame %d register $%02X: $%02X vs $%02X", d.Frame, d.Register, d.A, d.B)
+}
+
+// Verify plays a subtune of two tunes side by side and returns the first
+// difference of their SID registers, nil when they are the same
+func Verify(a, b *player.Tune, subtune int, frames int) (*Difference, error) {
+	pa, err := a.Play(subtune, frames)
+	if err != nil {
+		return nil, err
+	}
+	pb, err := b.Play(subtune, frames)
+	if err != nil {
+		return nil, err
+	}
+	for pa.Next() && pb.Next() {
+		for reg := 0; reg < 25; reg++ {
+			if pa.Sid.Register[reg] != pb.Sid.Register[reg] {
+				return &Difference{Frame: pa.Frame, Register: reg, A: pa.Sid.Register[reg], B: pb.Sid.Register[reg]}, nil
+			}
+		}
+	}
+	if err := pa.Err(); err != nil {
+		return nil, err
+	}
+	return nil, pb.Err()
+}
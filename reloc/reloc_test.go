@@ -0,0 +1,211 @@
+package reloc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"siddump/player"
+	"siddump/psid"
+
+	"github.com/beevik/go6502/asm"
+)
+
+// A tune using its addresses as instruction operands, through a pointer
+// in zero page and as return addresses. UNUSED is never touched and the
+// first byte of TABLE never read.
+const testSrc = `
+	.ORG $1000
+	JMP INIT
+	JMP PLAY
+INIT	LDA #$0F
+	STA $D418
+	LDA #$00
+	STA CNT
+	RTS
+PLAY	INC CNT
+	LDX CNT
+	LDA TABLE,X
+	STA $D400
+	JSR SETPTR
+	LDY #$00
+	LDA ($FB),Y
+	STA $D401
+	RTS
+SETPTR	LDA PTR
+	STA $FB
+	LDA PTR+1
+	STA $FC
+	RTS
+CNT	.DB 0
+PTR	.DW DATA
+DATA	.DB $12
+UNUSED	.DB $AA,$BB
+TABLE	.DB 1,2,3,4,5,6,7,8
+`
+
+// testTune assembles a tune at $1000
+func testTune(t *testing.T, src string) *player.Tune {
+	t.Helper()
+	a, _, err := asm.Assemble(strings.NewReader(src), "test.asm", 0x1000, io.Discard, 0)
+	if err != nil {
+		t.Fatalf("assembling: %v %v", err, a.Errors)
+	}
+	header := &psid.PSIDHeader{
+		MagicID:     [4]byte{'P', 'S', 'I', 'D'},
+		Version:     2,
+		LoadAddress: 0x1000,
+		InitAddress: 0x1000,
+		PlayAddress: 0x1003,
+		Songs:       1,
+		StartSong:   1,
+	}
+	var buf bytes.Buffer
+	if err := header.WritePSID(&buf, a.Code); err != nil {
+		t.Fatal(err)
+	}
+	tune, err := player.Read("test.sid", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tune
+}
+
+func TestRelocate(t *testing.T) {
+	tune := testTune(t, testSrc)
+	tests := []struct {
+		name    string
+		address uint16
+		err     error
+	}{
+		{"up", 0x4000, nil},
+		{"down", 0x0800, nil},
+		{"same place", 0x1000, nil},
+		{"not page aligned", 0x4080, ErrNotPageAligned},
+		{"zero page", 0x0000, ErrBadAddress},
+		{"I/O area", 0xD000, ErrBadAddress},
+		{"stack", 0x0100, ErrBadAddress},
+	}
+	for _, test := range tests {
+		res, err := Relocate(tune, test.address, 7)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		moved, err := player.Read("moved.sid", bytes.NewReader(res.File))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if moved.Header.LoadAddress != test.address || moved.Header.InitAddress != test.address || moved.Header.PlayAddress != test.address+3 {
+			t.Errorf("%s: addresses $%04X $%04X $%04X", test.name, moved.Header.LoadAddress, moved.Header.InitAddress, moved.Header.PlayAddress)
+		}
+		// JMP INIT, JMP PLAY, STA CNT, INC CNT, LDX CNT, LDA TABLE,X,
+		// JSR SETPTR, LDA PTR, LDA PTR+1 and the high byte of PTR
+		if res.Relocated != 10 {
+			t.Errorf("%s: %d bytes relocated, want 10", test.name, res.Relocated)
+		}
+		if len(res.Unrelocatable) != 0 {
+			t.Errorf("%s: unrelocatable %v", test.name, res.Unrelocatable)
+		}
+		// UNUSED and the first byte of TABLE
+		want := []Range{{0x1037, 0x1039}}
+		if !reflect.DeepEqual(res.Unreached, want) {
+			t.Errorf("%s: unreached %v, want %v", test.name, res.Unreached, want)
+		}
+		diff, err := Verify(tune, moved, 0, 20)
+		if err != nil || diff != nil {
+			t.Errorf("%s: relocated tune differs: %v %v", test.name, diff, err)
+		}
+	}
+}
+
+// A tune building its pointer by adding the high byte of an address to a
+// constant it also writes to the SID, from an immediate operand and from a
+// table
+const testAddSrc = `
+	.ORG $1000
+	JMP INIT
+	JMP PLAY
+INIT	LDA #$0F
+	STA $D418
+	RTS
+PLAY	LDA #<TABLE
+	STA $FB
+	LDA #$00
+	STA $D405
+	CLC
+	ADC #>TABLE
+	STA $FC
+	LDY #$00
+	LDA ($FB),Y
+	STA $D400
+	LDX #$00
+	LDA #$00
+	STA $D406
+	CLC
+	ADC HITAB,X
+	STA $FC
+	INY
+	LDA ($FB),Y
+	STA $D401
+	RTS
+HITAB	.DB >TABLE
+TABLE	.DB $11,$22
+`
+
+func TestRelocateAddedHighByte(t *testing.T) {
+	tune := testTune(t, testAddSrc)
+	res, err := Relocate(tune, 0x3000, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// JMP INIT, JMP PLAY, ADC #>TABLE, ADC HITAB,X and HITAB, but not the
+	// constants they are added to
+	if res.Relocated != 5 {
+		t.Errorf("%d bytes relocated, want 5", res.Relocated)
+	}
+	moved, err := player.Read("moved.sid", bytes.NewReader(res.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tune.Data {
+		if moved.Data[i] != tune.Data[i] && tune.Data[i] != 0x10 {
+			t.Errorf("$%04X: $%02X relocated to $%02X", 0x1000+i, tune.Data[i], moved.Data[i])
+		}
+	}
+	diff, err := Verify(tune, moved, 0, 5)
+	if err != nil || diff != nil {
+		t.Errorf("relocated tune differs: %v %v", diff, err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	if got := (Range{0x1000, 0x1000}).String(); got != "$1000" {
+		t.Errorf("got %s, want $1000", got)
+	}
+	if got := (Range{0x1000, 0x10FF}).String(); got != "$1000-$10FF" {
+		t.Errorf("got %s, want $1000-$10FF", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tune := testTune(t, testSrc)
+	other := *tune
+	other.Data = append([]byte(nil), tune.Data...)
+	// Change the fourth byte of TABLE
+	other.Data[0x3C] ^= 0xFF
+	diff, err := Verify(tune, &other, 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Difference{Frame: 2, Register: 0, A: 4, B: 4 ^ 0xFF}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("got %v, want %v", diff, want)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"siddump/player"
+	"siddump/reloc"
+)
+
+// Relocate moves a tune to a new load address, writes it to outName and
+// checks that all subtunes play the same as the original
+func (d *Dumper) Relocate(sidName string, address string, outName string, log io.Writer) error {
+	addr, err := parseAddress(address)
+	if err != nil {
+		return err
+	}
+	tune, err := d.open(sidName)
+	if err != nil {
+		return err
+	}
+	frames := d.Options.Seconds * 50
+
+	res, err := reloc.Relocate(tune, addr, frames)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(log, "Relocated $%04X to $%04X, %d address bytes changed\n", tune.Header.LoadAddress, addr, res.Relocated)
+	for _, pc := range res.Unrelocatable {
+		fmt.Fprintf(log, "Warning: instruction at $%04X uses a computed address\n", pc)
+	}
+	unreached := 0
+	for _, r := range res.Unreached {
+		fmt.Fprintf(log, "Warning: %s never used while playing, addresses in it were not relocated\n", r)
+		unreached += int(r.Last-r.First) + 1
+	}
+	if err := os.WriteFile(outName, res.File, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(log, "Wrote %s\n", outName)
+
+	// Play both versions side by side
+	relocated, err := player.Open(outName)
+	if err != nil {
+		return err
+	}
+	failed := false
+	for subtune := 0; subtune < int(tune.Header.Songs) || subtune == 0; subtune++ {
+		diff, err := reloc.Verify(tune, relocated, subtune, frames)
+		switch {
+		case err != nil:
+			return err
+		case diff != nil:
+			fmt.Fprintf(log, "Subtune %d: differs at %s\n", subtune+1, diff)
+			failed = true
+		default:
+			fmt.Fprintf(log, "Subtune %d: same SID registers for %d frames\n", subtune+1, frames)
+		}
+	}
+	if failed {
+		return errors.New("relocated tune does not play the same")
+	}
+	if unreached > 0 {
+		fmt.Fprintf(log, "Warning: %d bytes of the tune were not used in %d frames, the relocated tune may fail later; check it with a longer -t\n", unreached, frames)
+	}
+	return nil
+}
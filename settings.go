@@ -1,40 +1,143 @@
 package main
 
-import "flag"
-
-type SidOutputSettings struct {
-	Basefreq      int
-	Basenote      int
-	Firstframe    int
-	Lowres        int
-	Oldnotefactor int
-	Pattspacing   int
-	Profiling     int
-	Subtune       int
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"siddump/decoders"
+)
+
+// Settings holds the command line options: the decoder options plus the
+// ones controlling the run
+type Settings struct {
+	decoders.SidOutputSettings
 	Seconds       int
-	Spacing       int
-	Timeseconds   int
 	Usage         int
-	DecoderOutput int
+	DecoderOutput string
+	Outputs       []OutputSpec
+	Loop          int
+	Songlengths   string
+	Stil          string
+	Sidid         string
+	Workers       int
+	Timeout       int
+	Heatmap       string
+	Output        string
+	Trace         string
+	TraceFrames   string
+	MemMap        string
+	Edit          EditSettings
+	Load          string
+	Init          string
+	Play          string
+	MusPlayer     string
+	Tolerance     int
+	DiffLines     int
+}
+
+// EditSettings are the header fields changed by the edit command. Empty
+// strings leave a field unchanged.
+type EditSettings struct {
+	Name      string
+	Author    string
+	Released  string
+	Songs     string
+	StartSong string
+	Speed     string
+	Clock     string
+	Model     string
 }
 
-func NewSidOutputSettings() *SidOutputSettings {
-	opt := &SidOutputSettings{}
+func NewSettings() *Settings {
+	opt := &Settings{}
 	return opt
 }
-func (opt *SidOutputSettings) ParseArgs() {
+
+func (opt *Settings) ParseArgs(args []string) {
 	flag.IntVar(&opt.Subtune, "a", 0, "Accumulator value on init (subtune number) default = 0")
 	flag.IntVar(&opt.Basefreq, "c", 0, "Frequency recalibration. Give note frequency in hex")
 	flag.IntVar(&opt.Basenote, "d", 0xb0, "Select calibration note (abs.notation 80-DF). Default middle-C (B0)")
 	flag.IntVar(&opt.Firstframe, "f", 0, "First frame to display, default 0")
 	flag.IntVar(&opt.Lowres, "l", 1, "Low-resolution mode (only display 1 row per note)")
-	flag.IntVar(&opt.DecoderOutput, "m", 0, "Output mode, default 0")
-	flag.IntVar(&opt.Spacing, "n", 0, "Note spacing, default 0 (none)")
+	flag.StringVar(&opt.DecoderOutput, "m", "0", "Output modes, comma separated, each optionally followed by =file to write it to, - for standard output: 0 notes, 1 registers, 4 binary dump, 5 GoatTracker .sng, 6 XM module, 7 piano roll PNG/SVG, 8 HTML report, 9 register statistics. Default 0")
+	flag.IntVar(&opt.Spacing, "n", 0, "Note spacing, default 0 (none). Also the frames per row of the GoatTracker (3 or more, else 6) and XM (else 6) exports")
 	flag.IntVar(&opt.Oldnotefactor, "o", 1, "'Oldnote-sticky' factor. Default 1, increase for better vibrato display")
-	flag.IntVar(&opt.Pattspacing, "p", 0, "Pattern spacing, default 0 (none)")
+	flag.IntVar(&opt.Pattspacing, "p", 0, "Pattern spacing, default 0 (none). Also the rows per pattern of the GoatTracker (default 32) and XM (default 64) exports")
 	flag.IntVar(&opt.Timeseconds, "s", 0, "Display time in minutes:seconds:frame format")
 	flag.IntVar(&opt.Seconds, "t", 60, "Playback time in seconds, default 60")
 	flag.IntVar(&opt.Usage, "h", 0, "Display usage information")
 	flag.IntVar(&opt.Profiling, "z", 0, "Include CPU cycles+rastertime (PAL)+rastertime, badline corrected")
-	flag.Parse()	
-}
\ No newline at end of file
+	flag.StringVar(&opt.Heatmap, "heatmap", "", "Write register change heatmap PNG to file (output mode 9)")
+	flag.IntVar(&opt.Loop, "loop", 0, "Stop when the tune loops or goes silent, -t is the maximum time")
+	flag.StringVar(&opt.Songlengths, "songlengths", "", "HVSC Songlengths.md5 file to take the playback time from")
+	flag.StringVar(&opt.Stil, "stil", "", "HVSC STIL.txt file to show tune information from")
+	flag.StringVar(&opt.Sidid, "sidid", "", "SIDId signature file to identify the music player with")
+	flag.IntVar(&opt.Workers, "j", runtime.NumCPU(), "Number of tunes dumped in parallel in batch mode")
+	flag.StringVar(&opt.Output, "out", "sidtune", "Base name of output files. Ending in / writes them to that directory named after the tune, empty writes them next to the tune")
+	flag.StringVar(&opt.Trace, "trace", "", "Write a trace of every executed instruction to file")
+	flag.StringVar(&opt.TraceFrames, "traceframes", "", "Routine calls to trace, e.g. init,0-99,500. Default init and all frames")
+	flag.StringVar(&opt.MemMap, "memmap", "", "Write the memory accessed by the init and play routines to file, and as a PNG image next to it")
+	flag.StringVar(&opt.Load, "load", "", "Load address in hex of a raw binary without PSID header")
+	flag.StringVar(&opt.Init, "init", "", "Init address in hex of a .prg file or raw binary, default the load address. Overrides the init address of PSID files")
+	flag.StringVar(&opt.Play, "play", "", "Play address in hex of a .prg file or raw binary, default 0 (interrupt set up by init). Overrides the play address of PSID files")
+	flag.StringVar(&opt.MusPlayer, "musplayer", "", "Compute's Sidplayer routine as .prg or PSID file, to play .mus and .str files with it. Without it only their notes are played")
+	flag.IntVar(&opt.Tolerance, "tolerance", 2, "Largest frame offset between two tunes the diff command looks for, default 2")
+	flag.IntVar(&opt.DiffLines, "difflines", 16, "Frames shown side by side from the first difference by the diff command, default 16")
+	flag.StringVar(&opt.Edit.Name, "name", "", "New name of the tune (edit)")
+	flag.StringVar(&opt.Edit.Author, "author", "", "New author of the tune (edit)")
+	flag.StringVar(&opt.Edit.Released, "released", "", "New release year and publisher of the tune (edit)")
+	flag.StringVar(&opt.Edit.Songs, "songs", "", "New number of songs (edit)")
+	flag.StringVar(&opt.Edit.StartSong, "startsong", "", "New start song, 1-based (edit)")
+	flag.StringVar(&opt.Edit.Speed, "speed", "", "New speed bits in hex, one per song: 0 vertical blank, 1 CIA timer (edit)")
+	flag.StringVar(&opt.Edit.Clock, "clock", "", "New clock speed: unknown, pal, ntsc or any (edit)")
+	flag.StringVar(&opt.Edit.Model, "model", "", "New SID model: unknown, 6581, 8580 or any (edit)")
+	flag.IntVar(&opt.Timeout, "timeout", 0, "Give up on a tune after this many seconds in batch mode, default 0 (no limit)")
+	flag.CommandLine.Parse(args)
+}
+
+// parseAddress reads a hexadecimal address, with an optional $ or 0x
+// prefix. An empty string is 0.
+func parseAddress(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+	addr, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(s, "$"), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("bad address %s", s)
+	}
+	return uint16(addr), nil
+}
+
+// OutputSpec is one output decoder requested with -m. Its text output
+// goes to Path, or to standard output when empty.
+type OutputSpec struct {
+	Mode int
+	Path string
+}
+
+// ParseOutputs parses a comma separated list of output modes, e.g.
+// "0,1=registers.txt,4"
+func ParseOutputs(list string) ([]OutputSpec, error) {
+	var outputs []OutputSpec
+	seen := make(map[int]bool)
+	for _, item := range strings.Split(list, ",") {
+		mode, path, _ := strings.Cut(strings.TrimSpace(item), "=")
+		m, err := strconv.Atoi(mode)
+		if err != nil {
+			return nil, fmt.Errorf("bad output mode %q", item)
+		}
+		if seen[m] {
+			return nil, fmt.Errorf("output mode %d given twice", m)
+		}
+		seen[m] = true
+		outputs = append(outputs, OutputSpec{Mode: m, Path: path})
+	}
+	if len(outputs) == 0 {
+		return nil, errors.New("no output mode")
+	}
+	return outputs, nil
+}
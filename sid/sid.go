@@ -1,12 +1,18 @@
-package main
+package sid
 
-import "github.com/beevik/go6502/cpu"
+import (
+	"siddump/c64"
+
+	"github.com/beevik/go6502/cpu"
+)
 
 // Sid represents a SID chip.
 type Sid struct {
 	Channel [3]Voice
 	Filt    Filter
 	Register [27] byte
+	// Number of stores to each register since the previous frame
+	Writes [25]uint8
 }
 
 // Voice represents a voice in the SID chip.
@@ -18,6 +24,12 @@ type Voice struct {
 	Note  int
 }
 
+// SID envelope attack rates in milliseconds
+var AttackMs = []int{2, 8, 16, 24, 38, 56, 68, 80, 100, 250, 500, 800, 1000, 3000, 5000, 8000}
+
+// SID envelope decay/release rates in milliseconds
+var DecayMs = []int{6, 24, 48, 72, 114, 168, 204, 240, 300, 750, 1500, 2400, 3000, 9000, 15000, 24000}
+
 // Filter represents the filter in the SID chip.
 type Filter struct {
 	Type    uint8
@@ -69,6 +81,21 @@ func (sid *Sid) CopyFrom(src *Sid) {
 	sid.Filt.CopyFrom(&src.Filt)
 
 	copy(sid.Register[:], src.Register[:])
+	copy(sid.Writes[:], src.Writes[:])
+}
+
+// CopyRegisters copies the register state of src, keeping the notes the
+// decoders worked out for the voices
+func (sid *Sid) CopyRegisters(src *Sid) {
+	for i := range sid.Channel {
+		note := sid.Channel[i].Note
+		sid.Channel[i].CopyFrom(&src.Channel[i])
+		sid.Channel[i].Note = note
+	}
+	sid.Filt.CopyFrom(&src.Filt)
+
+	copy(sid.Register[:], src.Register[:])
+	copy(sid.Writes[:], src.Writes[:])
 }
 
 func (sid *Sid) CopyFromCpu(cpu *cpu.CPU) {
@@ -89,6 +116,11 @@ func (sid *Sid) CopyFromCpu(cpu *cpu.CPU) {
 		sid.Register[i] = cpu.Mem.LoadByte(uint16(0xD400+i))
 	}
 
+	if mem, ok := cpu.Mem.(*c64.Memory); ok {
+		sid.Writes = mem.SidWrites
+		mem.SidWrites = [25]uint8{}
+	}
+
 	if (cpu.Mem.LoadByte(0xDC05) == 0 && cpu.Mem.LoadByte(0xDC04) == 0) {
 		// Most likely vbi driven, ie. 20000us. Assume PAL, 50Hz.
 		sid.Register[25] = 0x4e; // dt HI